@@ -0,0 +1,129 @@
+package koshka
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// A Backend knows how to read from and list a particular kind of storage.
+// Each backend is registered against one or more URL schemes, e.g. s3, http.
+// Local files are handled by the backend registered for the empty scheme.
+//
+type Backend interface {
+	// Open returns a stream of the contents of the object at rawUrl.
+	Open(rawUrl string) (io.ReadCloser, error)
+	// Stat returns information about the object at rawUrl.
+	Stat(rawUrl string) (ObjectInfo, error)
+	// List returns the full URLs of the entries that begin with prefix, one
+	// level deep.  Directory-like entries end with a slash.
+	List(prefix string) ([]string, error)
+}
+
+type ObjectInfo struct {
+	Url     string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+	// ETag identifies the version of the object's contents, if the backend
+	// knows it, e.g. S3 and most HTTP servers
+	ETag string
+	// ContentType is the media type of the object, if the backend knows it,
+	// e.g. from S3 metadata or the HTTP Content-Type header
+	ContentType string
+	// StorageClass is where the backend keeps the object, e.g. STANDARD or
+	// GLACIER for S3
+	StorageClass string
+	// Metadata is what the user attached to the object when they stored it,
+	// e.g. the x-amz-meta-* headers of S3
+	Metadata map[string]string
+	// Checksums of the object as stored, hex-encoded and keyed by algorithm,
+	// see checksumAlgorithms
+	Checksums map[string]string
+}
+
+var (
+	backendsMutex sync.RWMutex
+	backends      = make(map[string]Backend)
+)
+
+//
+// Register makes backend responsible for URLs with the given scheme,
+// replacing any backend previously registered for that scheme.
+//
+func Register(scheme string, backend Backend) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+	backends[strings.ToLower(scheme)] = backend
+}
+
+func lookupBackend(scheme string) (Backend, error) {
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+	backend, ok := backends[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme: %s", scheme)
+	}
+	return backend, nil
+}
+
+//
+// Extract the scheme from a URL, without requiring the rest of the URL to be
+// well-formed.  Anything without a scheme is a local path.  Single-letter
+// schemes are Windows drive letters, so they are local paths too.
+//
+func schemeOf(rawUrl string) string {
+	colon := strings.Index(rawUrl, ":")
+	if colon < 2 {
+		return ""
+	}
+	for i, c := range rawUrl[:colon] {
+		isAlpha := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+		isOther := ('0' <= c && c <= '9') || c == '+' || c == '-' || c == '.'
+		if !isAlpha && (i == 0 || !isOther) {
+			return ""
+		}
+	}
+	return strings.ToLower(rawUrl[:colon])
+}
+
+func backendFor(rawUrl string) (Backend, error) {
+	return lookupBackend(schemeOf(rawUrl))
+}
+
+//
+// Open returns a stream of the contents of the object at rawUrl, using
+// whatever backend is registered for its scheme.
+//
+func Open(rawUrl string) (io.ReadCloser, error) {
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(rawUrl)
+}
+
+//
+// Stat returns information about the object at rawUrl.
+//
+func Stat(rawUrl string) (ObjectInfo, error) {
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return backend.Stat(rawUrl)
+}
+
+//
+// List returns the entries that begin with prefix, one level deep.
+//
+func List(prefix string) ([]string, error) {
+	backend, err := backendFor(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return backend.List(prefix)
+}
@@ -0,0 +1,153 @@
+package koshka
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//
+// Completion lists remote prefixes on every TAB press, which is slow over a
+// high-latency link.  So, we cache the listings on disk for a while: one
+// minute by default, or whatever the cache_ttl key in kot.cfg says, e.g.
+//
+//	[s3://bucket]
+//	cache_ttl = 10m  # set this to 0 to disable caching
+//
+// Writing to a URL invalidates the cached listings that could include it.
+//
+const defaultCacheTtl = time.Minute
+
+type cacheEntry struct {
+	Section    string
+	Prefix     string
+	Created    time.Time
+	Candidates []string
+}
+
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "koshka", "listings"), nil
+}
+
+//
+// The most specific section of kot.cfg that applies to rawUrl, if any.
+// Listings depend on e.g. the credentials, so they are cached per section.
+//
+func cacheSection(rawUrl string) (section string, ttl time.Duration) {
+	ttl = defaultCacheTtl
+	values, err := ExplainConfig(rawUrl, "")
+	if err != nil {
+		return "", ttl
+	}
+	for _, value := range values {
+		if len(value.Section) > len(section) {
+			section = value.Section
+		}
+		if value.Key == "cache_ttl" {
+			if parsed, err := time.ParseDuration(value.Value); err == nil {
+				ttl = parsed
+			}
+		}
+	}
+	return section, ttl
+}
+
+func cachePath(section, prefix string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(section + "\n" + prefix))
+	return filepath.Join(dir, fmt.Sprintf("%x.json", digest)), nil
+}
+
+//
+// List prefix using backend, going via the cache for remote backends
+//
+func cachedList(backend Backend, prefix string) ([]string, error) {
+	if scheme := schemeOf(prefix); scheme == "" || scheme == "file" {
+		return backend.List(prefix)
+	}
+
+	section, ttl := cacheSection(prefix)
+	if ttl <= 0 {
+		return backend.List(prefix)
+	}
+	path, err := cachePath(section, prefix)
+	if err != nil {
+		return backend.List(prefix)
+	}
+
+	var entry cacheEntry
+	if data, err := os.ReadFile(path); err == nil {
+		err = json.Unmarshal(data, &entry)
+		if err == nil && entry.Prefix == prefix && time.Since(entry.Created) < ttl {
+			return entry.Candidates, nil
+		}
+	}
+
+	candidates, err := backend.List(prefix)
+	if err != nil {
+		return candidates, err
+	}
+
+	// Failing to cache is no reason to fail the listing
+	entry = cacheEntry{Section: section, Prefix: prefix, Created: time.Now(), Candidates: candidates}
+	if data, err := json.Marshal(entry); err == nil {
+		if os.MkdirAll(filepath.Dir(path), 0o700) == nil {
+			os.WriteFile(path, data, 0o600)
+		}
+	}
+	return candidates, nil
+}
+
+//
+// InvalidateCache removes the cached listings that could include rawUrl,
+// e.g. after writing to it
+//
+func InvalidateCache(rawUrl string) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range entries {
+		path := filepath.Join(dir, dirEntry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if json.Unmarshal(data, &entry) != nil || strings.HasPrefix(rawUrl, entry.Prefix) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+//
+// ClearCache removes all cached listings
+//
+func ClearCache() error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,74 @@
+package koshka
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type countingBackend struct {
+	localBackend
+	lists int
+}
+
+func (b *countingBackend) List(prefix string) ([]string, error) {
+	b.lists++
+	return []string{prefix + "a", prefix + "b/"}, nil
+}
+
+func Test_cachedList(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	backend := &countingBackend{}
+
+	list := func(prefix string) {
+		t.Helper()
+		expected := []string{prefix + "a", prefix + "b/"}
+		actual, err := cachedList(backend, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", prefix, expected, actual)
+		}
+	}
+	expectLists := func(expected int) {
+		t.Helper()
+		if backend.lists != expected {
+			t.Errorf("expected %d listings, got %d", expected, backend.lists)
+		}
+	}
+
+	list("cached://bucket/dir/")
+	list("cached://bucket/dir/")
+	list("cached://bucket/other/")
+	expectLists(2)
+
+	if err := InvalidateCache("cached://bucket/dir/new"); err != nil {
+		t.Fatal(err)
+	}
+	list("cached://bucket/dir/")
+	list("cached://bucket/other/")
+	expectLists(3)
+
+	if err := ClearCache(); err != nil {
+		t.Fatal(err)
+	}
+	list("cached://bucket/other/")
+	expectLists(4)
+
+	// Local listings are cheap, and we don't want them to go stale
+	list(home + "/")
+	list(home + "/")
+	expectLists(6)
+
+	cfg := "[cached://bucket/nocache/]\ncache_ttl = 0\n"
+	if err := os.WriteFile(filepath.Join(home, "kot.cfg"), []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	list("cached://bucket/nocache/")
+	list("cached://bucket/nocache/")
+	expectLists(8)
+}
@@ -1,11 +1,11 @@
 package koshka
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -14,67 +14,306 @@ type CfgSection struct {
 	items map[string]string
 }
 
+//
+// LoadConfig reads an INI-style configuration file, by default ~/kot.cfg:
+//
+//	# comments start with # or ;
+//	[s3://mybucket]
+//	endpoint_url = http://localhost:4566  # so do inline comments
+//	secret = abc==                        # only the first = separates the key
+//	quoted = "tab\there, newline\nthere"  # escapes work in double quotes
+//	multiline = "first line
+//	second line"
+//	literal = '$HOME stays as is'          # no escapes or interpolation in single quotes
+//	home = $HOME/${USER}                   # environment variables are interpolated
+//	include = ~/other.cfg                  # relative to the including file
+//
+// The sections of an included file are added to the result at the point of
+// the include directive.  Keys after the include directive still belong to
+// the section that it appears in, if any.
+//
 func LoadConfig(path string) ([]CfgSection, error) {
 	if path == "" {
 		path = os.ExpandEnv("$HOME/kot.cfg")
 	}
+	return loadConfig(path, make(map[string]bool))
+}
 
+func loadConfig(path string, including map[string]bool) ([]CfgSection, error) {
 	var result []CfgSection
 
-	fin, err := os.Open(path)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return result, err
+	}
+	if including[absPath] {
+		return result, fmt.Errorf("%s: circular include", path)
+	}
+	including[absPath] = true
+	defer delete(including, absPath)
+
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return result, err
 	}
-	defer fin.Close()
-	reader := bufio.NewReader(fin)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
 
-	var currentSection CfgSection
-	outputSection := false
+	// The index of the section that keys go into, which isn't necessarily the
+	// last one, because includes may add sections of their own
+	current := -1
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
 
-	for true {
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return result, err
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			// Skip comments
+			continue
+		}
+
+		if line[0] == '[' {
+			name, rest, found := strings.Cut(line[1:], "]")
+			if !found || stripComment(rest) != "" {
+				return result, fmt.Errorf("%s:%d: malformed section header: %q", path, lineNumber, line)
+			}
+			result = append(result, CfgSection{strings.TrimSpace(name), make(map[string]string)})
+			current = len(result) - 1
+			continue
 		}
 
-		line = strings.Trim(line, "\n")
+		key, rawValue, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return result, fmt.Errorf("%s:%d: malformed line: %q", path, lineNumber, line)
+		}
 
-		if len(line) == 0 || line[0] == '#' {
-			// Skip comments
+		value, extraLines, err := parseValue(rawValue, lines[i+1:])
+		if err != nil {
+			return result, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		i += extraLines
+
+		if key == "include" {
+			included, err := loadConfig(includePath(path, value), including)
+			if err != nil {
+				return result, fmt.Errorf("%s:%d: unable to include %q: %w", path, lineNumber, value, err)
+			}
+			result = append(result, included...)
 			continue
 		}
 
-		if line[0] == '[' && line[len(line) - 1] == ']' {
-			if outputSection {
-				result = append(result, currentSection)
+		if current < 0 {
+			return result, fmt.Errorf("%s:%d: %q is outside of any section", path, lineNumber, key)
+		}
+		result[current].items[key] = value
+	}
+
+	return result, nil
+}
+
+//
+// Parse the part of a line after the equals sign.  Double-quoted values may
+// continue onto the following lines, so return the number of those we used.
+//
+func parseValue(rawValue string, following []string) (value string, extraLines int, err error) {
+	rawValue = strings.TrimSpace(rawValue)
+
+	switch {
+	case strings.HasPrefix(rawValue, "'"):
+		value, rest, found := strings.Cut(rawValue[1:], "'")
+		if !found {
+			return "", 0, errors.New("unterminated single-quoted value")
+		}
+		if stripComment(rest) != "" {
+			return "", 0, fmt.Errorf("unexpected text after closing quote: %q", rest)
+		}
+		return value, 0, nil
+
+	case strings.HasPrefix(rawValue, "\""):
+		var builder strings.Builder
+		text := rawValue[1:]
+		for {
+			for i := 0; i < len(text); i++ {
+				switch text[i] {
+				case '"':
+					if rest := text[i+1:]; stripComment(rest) != "" {
+						return "", 0, fmt.Errorf("unexpected text after closing quote: %q", rest)
+					}
+					return builder.String(), extraLines, nil
+				case '$':
+					expanded, next := expandVariable(text, i)
+					builder.WriteString(expanded)
+					i = next - 1
+				case '\\':
+					if i+1 == len(text) {
+						return "", 0, errors.New("unterminated escape sequence")
+					}
+					i++
+					escaped, ok := escapes[text[i]]
+					if !ok {
+						return "", 0, fmt.Errorf("unknown escape sequence: \\%c", text[i])
+					}
+					builder.WriteByte(escaped)
+				default:
+					builder.WriteByte(text[i])
+				}
 			}
-			currentSection.name = line[1:len(line) - 1]
-			currentSection.items = make(map[string]string)
-			outputSection = true
-		} else {
-			parts := strings.Split(line, "=")
-			if len(parts) != 2 {
-				return result, errors.New(fmt.Sprintf("malformed line: %q", line))
+
+			if extraLines == len(following) {
+				return "", 0, errors.New("unterminated double-quoted value")
 			}
-			key := strings.Trim(parts[0], " ")
-			value := strings.Trim(parts[1], " ")
-			currentSection.items[key] = value
-			outputSection = true
+			builder.WriteByte('\n')
+			text = following[extraLines]
+			extraLines++
 		}
+
+	default:
+		return interpolate(stripComment(rawValue)), 0, nil
 	}
-	if outputSection {
-		result = append(result, currentSection)	
+}
+
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+	'$':  '$',
+}
+
+//
+// Remove an inline comment, i.e. a # or ; at the start of the text or after
+// whitespace.  Requiring the whitespace keeps URL fragments and query strings
+// like ?C=N;O=D intact.
+//
+func stripComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '#' && text[i] != ';' {
+			continue
+		}
+		if i == 0 || text[i-1] == ' ' || text[i-1] == '\t' {
+			text = text[:i]
+			break
+		}
 	}
+	return strings.TrimSpace(text)
+}
 
-	return result, nil
+//
+// Replace $NAME and ${NAME} with the values of environment variables
+//
+func interpolate(text string) string {
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' {
+			builder.WriteByte(text[i])
+			continue
+		}
+		expanded, next := expandVariable(text, i)
+		builder.WriteString(expanded)
+		i = next - 1
+	}
+	return builder.String()
+}
+
+//
+// Expand the variable reference starting with the dollar sign at text[start],
+// and return the index of the first character after the reference.  A dollar
+// sign that doesn't start a reference stays as is.
+//
+func expandVariable(text string, start int) (string, int) {
+	if start+1 < len(text) && text[start+1] == '{' {
+		if end := strings.IndexByte(text[start:], '}'); end > 0 {
+			return os.Getenv(text[start+2 : start+end]), start + end + 1
+		}
+		return "$", start + 1
+	}
+
+	end := start + 1
+	for end < len(text) && isVariableChar(text[end]) {
+		end++
+	}
+	if end == start+1 {
+		return "$", end
+	}
+	return os.Getenv(text[start+1 : end]), end
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+//
+// Included paths are relative to the file that includes them
+//
+func includePath(includingPath, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(includingPath), path)
 }
 
 //
 // Load the relevant configuration from ~/kot.cfg
 //
 func findConfig(prefix string, path string) (map[string]string, error) {
+	values, err := ExplainConfig(prefix, path)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]string)
+	for _, value := range values {
+		items[value.Key] = value.Value
+	}
+	return items, nil
+}
+
+//
+// A ConfigValue is the value of a key that applies to a URL, along with the
+// section it came from, and the broader sections that it overrides.
+//
+type ConfigValue struct {
+	Key        string
+	Value      string
+	Section    string
+	Overridden []string
+}
+
+//
+// Parts of key names that give away a secret value, e.g. password,
+// secret_access_key, session_token or header.X-Api-Key
+//
+var secretKeyParts = []string{"password", "secret", "token", "authorization", "api-key", "api_key", "apikey"}
+
+//
+// MaskedValue is the value with secrets blanked out, for showing to people,
+// e.g. in kot config explain
+//
+func (v ConfigValue) MaskedValue() string {
+	key := strings.ToLower(v.Key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) && v.Value != "" {
+			return "********"
+		}
+	}
+	return v.Value
+}
+
+//
+// ExplainConfig works out the configuration for rawUrl.  Every section whose
+// name is a prefix of rawUrl applies, and the values from longer, i.e. more
+// specific, sections override those from shorter ones, regardless of the
+// order of the sections in the file.  Aliases are a property of a particular
+// section, so they are not inherited.  The rawUrl may also be an alias, in
+// which case it stands for the name of the section it belongs to.
+//
+func ExplainConfig(rawUrl string, path string) ([]ConfigValue, error) {
 	if path == "" {
 		path = os.ExpandEnv("$HOME/kot.cfg")
 	}
@@ -84,11 +323,61 @@ func findConfig(prefix string, path string) (map[string]string, error) {
 		return nil, err
 	}
 
-	for _, section := range(sections) {
-		if strings.HasPrefix(prefix, section.name) || section.items["alias"] == prefix {
-			return section.items, nil
+	for _, section := range sections {
+		if section.items["alias"] == rawUrl {
+			rawUrl = section.name
+			break
 		}
 	}
-	return nil, errors.New(fmt.Sprintf("no matches found for prefix: %q", prefix))
+
+	var matching []CfgSection
+	for _, section := range sections {
+		if sectionMatches(section.name, rawUrl) {
+			matching = append(matching, section)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no matches found for prefix: %q", rawUrl)
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return len(matching[i].name) < len(matching[j].name)
+	})
+
+	values := make(map[string]*ConfigValue)
+	for _, section := range matching {
+		for key, value := range section.items {
+			if key == "alias" && section.name != rawUrl {
+				continue
+			}
+			if previous, ok := values[key]; ok {
+				previous.Overridden = append(previous.Overridden, previous.Section)
+				previous.Value, previous.Section = value, section.name
+			} else {
+				values[key] = &ConfigValue{Key: key, Value: value, Section: section.name}
+			}
+		}
+	}
+
+	var result []ConfigValue
+	for _, value := range values {
+		result = append(result, *value)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
 }
 
+//
+// A section applies to a URL if its name is a prefix of the URL ending at a
+// boundary, so that [s3://bucket] applies to s3://bucket/key but not to
+// s3://bucket2/key, and [https://example.com] doesn't leak its credentials
+// to https://example.com.evil.org.
+//
+func sectionMatches(name, rawUrl string) bool {
+	if !strings.HasPrefix(rawUrl, name) {
+		return false
+	}
+	if len(rawUrl) == len(name) || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ":") {
+		return true
+	}
+	return strings.ContainsRune("/?#:", rune(rawUrl[len(name)]))
+}
@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -91,3 +94,131 @@ func TestLoadConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadConfig_syntax(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("KOT_TEST_SECRET", "hunter2")
+	main := `# top comment
+[s3://bucket]
+endpoint_url = http://localhost:4566  # inline comment
+secret = YWJj==
+query = https://example.com/?C=N;O=D#fragment
+quoted = "tab\there # not a comment"  ; comment
+multiline = "first
+second"
+literal = '$KOT_TEST_SECRET\n'
+interpolated = ${KOT_TEST_SECRET}/$KOT_TEST_SECRET/$
+escaped = "\$KOT_TEST_SECRET"
+include = other.cfg
+after_include = bucket
+
+[https://example.com]
+username = secret
+`
+	other := "[s3://other]\nalias = other\n"
+	os.WriteFile(filepath.Join(dir, "kot.cfg"), []byte(main), 0o600)
+	os.WriteFile(filepath.Join(dir, "other.cfg"), []byte(other), 0o600)
+
+	actual, err := LoadConfig(filepath.Join(dir, "kot.cfg"))
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := []CfgSection{
+		{"s3://bucket", map[string]string{
+			"endpoint_url": "http://localhost:4566",
+			"secret":       "YWJj==",
+			"query":        "https://example.com/?C=N;O=D#fragment",
+			"quoted":       "tab\there # not a comment",
+			"multiline":    "first\nsecond",
+			"literal":      "$KOT_TEST_SECRET\\n",
+			"interpolated": "hunter2/hunter2/$",
+			"escaped":      "$KOT_TEST_SECRET",
+			// Not in s3://other, which came from the include
+			"after_include": "bucket",
+		}},
+		{"s3://other", map[string]string{"alias": "other"}},
+		{"https://example.com", map[string]string{"username": "secret"}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestLoadConfig_errors(t *testing.T) {
+	dir := t.TempDir()
+	testCases := map[string]string{
+		"[s3://bucket]\nfoo\n": "kot.cfg:2: malformed line",
+		"[s3://bucket\n":       "kot.cfg:1: malformed section header",
+		"key = value\n":        "kot.cfg:1: \"key\" is outside of any section",
+		"[s3://bucket]\n\nkey = \"unterminated\nmore\n": "kot.cfg:3: unterminated double-quoted value",
+		"[s3://bucket]\nkey = \"\\q\"\n":                "kot.cfg:2: unknown escape sequence",
+		"[s3://bucket]\ninclude = kot.cfg\n":            "kot.cfg:2: unable to include",
+		"include = other.cfg\nkey = value\n":            "kot.cfg:2: \"key\" is outside of any section",
+	}
+	os.WriteFile(filepath.Join(dir, "other.cfg"), []byte("[s3://other]\nalias = other\n"), 0o600)
+	for tc, expected := range testCases {
+		path := filepath.Join(dir, "kot.cfg")
+		os.WriteFile(path, []byte(tc), 0o600)
+		_, err := LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("tc: %q expected err containing %q, got %v", tc, expected, err)
+		}
+	}
+}
+
+func TestExplainConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kot.cfg")
+	cfg := `[s3://bucket/secure]
+profile = secure
+
+[s3://]
+region = us-east-1
+
+[s3://bucket]
+endpoint_url = http://localhost:4566
+profile = default
+alias = b
+
+[s3://bucket2]
+profile = other
+`
+	os.WriteFile(path, []byte(cfg), 0o600)
+
+	actual, err := ExplainConfig("s3://bucket/secure/key", path)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := []ConfigValue{
+		{"endpoint_url", "http://localhost:4566", "s3://bucket", nil},
+		{"profile", "secure", "s3://bucket/secure", []string{"s3://bucket"}},
+		{"region", "us-east-1", "s3://", nil},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	testCases := []struct {
+		value    ConfigValue
+		expected string
+	}{
+		{ConfigValue{Key: "password", Value: "nonono"}, "********"},
+		{ConfigValue{Key: "secret_access_key", Value: "sekrit"}, "********"},
+		{ConfigValue{Key: "session_token", Value: "sekrit"}, "********"},
+		{ConfigValue{Key: "header.X-Api-Key", Value: "abc"}, "********"},
+		{ConfigValue{Key: "access_key_id", Value: "AKIDKOT"}, "AKIDKOT"},
+		{ConfigValue{Key: "profile", Value: "secure"}, "secure"},
+	}
+	for _, tc := range testCases {
+		if actual := tc.value.MaskedValue(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.value.Key, tc.expected, actual)
+		}
+	}
+
+	items, err := findConfig("b", path)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if items["profile"] != "default" || items["alias"] != "b" || items["region"] != "us-east-1" {
+		t.Errorf("unexpected items for alias: %v", items)
+	}
+}
@@ -0,0 +1,133 @@
+package koshka
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type compression struct {
+	matches func(header []byte) bool
+	open    func(io.Reader) (io.ReadCloser, error)
+}
+
+func magic(prefix ...byte) func([]byte) bool {
+	return func(header []byte) bool {
+		return bytes.HasPrefix(header, prefix)
+	}
+}
+
+//
+// We go by the magic bytes at the start of the stream rather than the
+// extension, because the extension lies surprisingly often: HTTP servers
+// decode gzip Content-Encoding on the fly, people upload plain text as
+// whatever.txt.gz, etc.
+//
+var compressions = []compression{
+	{
+		matches: magic(0x1f, 0x8b),
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		matches: isBzip2,
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+	{
+		matches: magic(0x28, 0xb5, 0x2f, 0xfd),
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	},
+	{
+		matches: magic(0xfd, '7', 'z', 'X', 'Z', 0x00),
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			reader, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(reader), nil
+		},
+	},
+}
+
+type decompressingReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d decompressingReader) Close() error {
+	var err error
+	for _, closer := range d.closers {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+//
+// Wrap reader so that it yields decompressed data, if it is compressed in
+// one of the formats we know about.  Uncompressed data passes through as is.
+//
+func decompress(reader io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	header, _ := buffered.Peek(10)
+	for _, c := range compressions {
+		if !c.matches(header) {
+			continue
+		}
+		decoder, err := c.open(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decompressingReader{decoder, []io.Closer{decoder, reader}}, nil
+	}
+	return decompressingReader{buffered, []io.Closer{reader}}, nil
+}
+
+//
+// "BZh" is a bit too likely to occur at the start of a text file, so check
+// the block size and the magic number of the first block (pi) too
+//
+func isBzip2(header []byte) bool {
+	return len(header) >= 10 &&
+		bytes.HasPrefix(header, []byte("BZh")) &&
+		'1' <= header[3] && header[3] <= '9' &&
+		bytes.Equal(header[4:10], []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59})
+}
+
+//
+// Check the first few bytes of the object at rawUrl for compression, without
+// reading the entire object
+//
+func isCompressed(rawUrl string) (bool, error) {
+	reader, err := OpenRange(rawUrl, ByteRange{Offset: 0, Length: 10})
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	header, err := io.ReadAll(reader)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range compressions {
+		if c.matches(header) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
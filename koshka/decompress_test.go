@@ -0,0 +1,56 @@
+package koshka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func Test_decompress(t *testing.T) {
+	const expected = "hello, world\n"
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write([]byte(expected))
+	gzipWriter.Close()
+
+	var zstded bytes.Buffer
+	zstdWriter, _ := zstd.NewWriter(&zstded)
+	zstdWriter.Write([]byte(expected))
+	zstdWriter.Close()
+
+	var xzed bytes.Buffer
+	xzWriter, _ := xz.NewWriter(&xzed)
+	xzWriter.Write([]byte(expected))
+	xzWriter.Close()
+
+	testCases := map[string][]byte{
+		"gzip":  gzipped.Bytes(),
+		"zstd":  zstded.Bytes(),
+		"xz":    xzed.Bytes(),
+		"plain": []byte(expected),
+	}
+	for tc, input := range testCases {
+		reader, err := decompress(io.NopCloser(bytes.NewReader(input)))
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		actual, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+
+	// Text that merely looks like the start of a bzip2 stream
+	reader, _ := decompress(io.NopCloser(bytes.NewReader([]byte("BZh, said the cat"))))
+	if actual, _ := io.ReadAll(reader); string(actual) != "BZh, said the cat" {
+		t.Errorf("expected text to pass through, got %q", actual)
+	}
+}
@@ -0,0 +1,113 @@
+package koshka
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+//
+// FormatOptions correspond to the flags of GNU cat that affect the output
+//
+type FormatOptions struct {
+	NumberLines     bool // -n: number all output lines
+	NumberNonBlank  bool // -b: number nonempty output lines, overrides -n
+	SqueezeBlank    bool // -s: suppress repeated empty output lines
+	ShowEnds        bool // -E: display $ at end of each line
+	ShowTabs        bool // -T: display TAB characters as ^I
+	ShowNonPrinting bool // -v: use ^ and M- notation, except for LFD and TAB
+}
+
+//
+// A Formatter is a writer that transforms its input the same way GNU cat
+// does, and writes the result to the underlying writer.  Like GNU cat, it
+// carries its state over from one input to the next, so use the same
+// Formatter for all the inputs to get e.g. continuous line numbers.
+//
+type Formatter struct {
+	writer      io.Writer
+	options     FormatOptions
+	atLineStart bool
+	blankLines  int
+	lineNumber  int
+}
+
+func NewFormatter(writer io.Writer, options FormatOptions) *Formatter {
+	return &Formatter{writer: writer, options: options, atLineStart: true}
+}
+
+func (f *Formatter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, c := range p {
+		if f.atLineStart {
+			if c == '\n' {
+				f.blankLines++
+				if f.options.SqueezeBlank && f.blankLines > 1 {
+					continue
+				}
+				if f.options.NumberLines && !f.options.NumberNonBlank {
+					f.writeLineNumber(&buf)
+				}
+				f.writeNewline(&buf)
+				continue
+			}
+			f.blankLines = 0
+			if f.options.NumberLines || f.options.NumberNonBlank {
+				f.writeLineNumber(&buf)
+			}
+			f.atLineStart = false
+		}
+
+		switch {
+		case c == '\n':
+			f.writeNewline(&buf)
+			f.atLineStart = true
+		case c == '\t':
+			if f.options.ShowTabs {
+				buf.WriteString("^I")
+			} else {
+				buf.WriteByte(c)
+			}
+		case f.options.ShowNonPrinting:
+			writeNonPrinting(&buf, c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	if _, err := f.writer.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *Formatter) writeLineNumber(buf *bytes.Buffer) {
+	f.lineNumber++
+	fmt.Fprintf(buf, "%6d\t", f.lineNumber)
+}
+
+func (f *Formatter) writeNewline(buf *bytes.Buffer) {
+	if f.options.ShowEnds {
+		buf.WriteByte('$')
+	}
+	buf.WriteByte('\n')
+}
+
+//
+// The ^ and M- notation used by cat -v
+//
+func writeNonPrinting(buf *bytes.Buffer, c byte) {
+	if c >= 128 {
+		buf.WriteString("M-")
+		c -= 128
+	}
+	switch {
+	case c < 32:
+		buf.WriteByte('^')
+		buf.WriteByte(c + 64)
+	case c == 127:
+		buf.WriteString("^?")
+	default:
+		buf.WriteByte(c)
+	}
+}
@@ -0,0 +1,59 @@
+package koshka
+
+import (
+	"bytes"
+	"testing"
+)
+
+//
+// The expected outputs come from GNU cat 9.x, given the two inputs as two
+// separate files
+//
+func TestFormatter(t *testing.T) {
+	inputs := []string{"a\tb\n\n\n\nc\x01\x7f\xe9\x89\n\nlast", "x\n\n"}
+	for _, tc := range []struct {
+		flags    string
+		options  FormatOptions
+		expected string
+	}{
+		{
+			"-n",
+			FormatOptions{NumberLines: true},
+			"     1\ta\tb\n     2\t\n     3\t\n     4\t\n     5\tc\x01\x7f\xe9\x89\n     6\t\n     7\tlastx\n     8\t\n",
+		},
+		{
+			"-b",
+			FormatOptions{NumberNonBlank: true},
+			"     1\ta\tb\n\n\n\n     2\tc\x01\x7f\xe9\x89\n\n     3\tlastx\n\n",
+		},
+		{
+			"-s",
+			FormatOptions{SqueezeBlank: true},
+			"a\tb\n\nc\x01\x7f\xe9\x89\n\nlastx\n\n",
+		},
+		{
+			"-A",
+			FormatOptions{ShowNonPrinting: true, ShowEnds: true, ShowTabs: true},
+			"a^Ib$\n$\n$\n$\nc^A^?M-iM-^I$\n$\nlastx$\n$\n",
+		},
+		{
+			"-ns",
+			FormatOptions{NumberLines: true, SqueezeBlank: true},
+			"     1\ta\tb\n     2\t\n     3\tc\x01\x7f\xe9\x89\n     4\t\n     5\tlastx\n     6\t\n",
+		},
+		{
+			"-bs",
+			FormatOptions{NumberNonBlank: true, SqueezeBlank: true},
+			"     1\ta\tb\n\n     2\tc\x01\x7f\xe9\x89\n\n     3\tlastx\n\n",
+		},
+	} {
+		var buf bytes.Buffer
+		formatter := NewFormatter(&buf, tc.options)
+		for _, input := range inputs {
+			formatter.Write([]byte(input))
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("tc: %s expected %q, got %q", tc.flags, tc.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package koshka
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//
+// Listing everything can be too slow for completion, so backends may cut
+// List short, e.g. S3 narrows huge listings down to groups of keys.  A
+// FullListBackend can also list everything, which we need to e.g. expand
+// wildcards.
+//
+type FullListBackend interface {
+	ListFull(prefix string) ([]string, error)
+}
+
+func listFull(backend Backend, prefix string) ([]string, error) {
+	if fullListBackend, ok := backend.(FullListBackend); ok {
+		return fullListBackend.ListFull(prefix)
+	}
+	return backend.List(prefix)
+}
+
+//
+// ListAll is like List, but never cut short, e.g. for kot ls
+//
+func ListAll(prefix string) ([]string, error) {
+	backend, err := backendFor(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return listFull(backend, prefix)
+}
+
+//
+// A WalkBackend can list all the objects under a prefix, however deep, more
+// efficiently than listing one level at a time, e.g. S3 can do that by
+// listing without a delimiter.
+//
+type WalkBackend interface {
+	Walk(prefix string) ([]string, error)
+}
+
+//
+// Walk returns the URLs of all the objects under prefix, however deep, but
+// not the directories that contain them
+//
+func Walk(prefix string) ([]string, error) {
+	backend, err := backendFor(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return walk(backend, prefix)
+}
+
+func walk(backend Backend, prefix string) (objects []string, err error) {
+	if walkBackend, ok := backend.(WalkBackend); ok {
+		return walkBackend.Walk(prefix)
+	}
+	candidates, err := listFull(backend, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if !strings.HasSuffix(candidate, "/") {
+			objects = append(objects, candidate)
+			continue
+		}
+		if candidate == prefix {
+			continue
+		}
+		children, err := walk(backend, candidate)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, children...)
+	}
+	return objects, nil
+}
+
+//
+// Glob returns the URLs of the objects that match pattern, e.g.
+// s3://bucket/logs/2024-*/part-*.  The wildcards are * and [...], with the
+// same meaning as in path.Match, and they match within a single path segment.
+// A ** segment matches any number of directories, e.g. s3://bucket/**/*.json
+// matches JSON files anywhere in the bucket.  Unlike the shell, ? is not a
+// wildcard, because it starts URL queries.  A pattern without wildcards comes
+// back as is, without checking that it exists.
+//
+func Glob(pattern string) ([]string, error) {
+	if !hasWildcards(pattern) {
+		return []string{pattern}, nil
+	}
+	backend, err := backendFor(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return glob(backend, "", strings.SplitAfter(pattern, "/"))
+}
+
+func hasWildcards(text string) bool {
+	return strings.ContainsAny(text, "*[")
+}
+
+func isDoubleStar(segment string) bool {
+	return strings.TrimSuffix(segment, "/") == "**"
+}
+
+//
+// Expand the remaining segments of the pattern under prefix, where each
+// segment still has its trailing slash, if any
+//
+func glob(backend Backend, prefix string, segments []string) (matches []string, err error) {
+	literal := 0
+	for literal < len(segments) && !hasWildcards(segments[literal]) {
+		prefix += segments[literal]
+		literal++
+	}
+	if literal == len(segments) {
+		// The wildcards matched, but the rest of the path may not exist
+		candidates, err := listFull(backend, prefix)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			if candidate == prefix || strings.HasSuffix(prefix, "/") {
+				return []string{prefix}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	//
+	// Fetch everything under the prefix in one go, and match the rest of the
+	// pattern against the paths of the objects relative to the prefix
+	//
+	if isDoubleStar(segments[literal]) {
+		objects, err := walk(backend, prefix)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			if !strings.HasPrefix(object, prefix) {
+				continue
+			}
+			parts := strings.SplitAfter(object[len(prefix):], "/")
+			if matched, err := matchSegments(segments[literal:], parts); err != nil {
+				return nil, err
+			} else if matched {
+				matches = append(matches, object)
+			}
+		}
+		return matches, nil
+	}
+
+	segment := segments[literal]
+	isLast := literal == len(segments)-1
+
+	// List only the entries that start with the literal part of the segment.
+	// Stop short of any question mark, so that it doesn't look like a query.
+	listPrefix := prefix + segment[:strings.IndexAny(segment, "*[?")]
+	candidates, err := listFull(backend, listPrefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, prefix) {
+			continue
+		}
+		if matched, err := matchSegment(segment, candidate[len(prefix):]); err != nil {
+			return nil, err
+		} else if !matched {
+			continue
+		}
+
+		if isLast {
+			matches = append(matches, candidate)
+			continue
+		}
+		children, err := glob(backend, candidate, segments[literal+1:])
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, children...)
+	}
+	return matches, nil
+}
+
+//
+// Match the path segments of an object against those of a pattern, where
+// ** matches any number of segments
+//
+func matchSegments(patterns, parts []string) (bool, error) {
+	if len(patterns) == 0 {
+		return len(parts) == 0, nil
+	}
+	if isDoubleStar(patterns[0]) {
+		for i := 0; i <= len(parts); i++ {
+			if matched, err := matchSegments(patterns[1:], parts[i:]); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	if len(parts) == 0 {
+		return false, nil
+	}
+	if matched, err := matchSegment(patterns[0], parts[0]); err != nil || !matched {
+		return false, err
+	}
+	return matchSegments(patterns[1:], parts[1:])
+}
+
+//
+// Match a single path segment, where a trailing slash only matches a
+// directory, and ? is just a question mark
+//
+func matchSegment(pattern, name string) (bool, error) {
+	if strings.HasSuffix(pattern, "/") != strings.HasSuffix(name, "/") {
+		return false, nil
+	}
+	pattern = strings.ReplaceAll(strings.TrimSuffix(pattern, "/"), "?", "\\?")
+	return path.Match(pattern, strings.TrimSuffix(name, "/"))
+}
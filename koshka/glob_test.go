@@ -0,0 +1,179 @@
+package koshka
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlob_local(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"logs/2023-12/part-0",
+		"logs/2024-01/part-0",
+		"logs/2024-01/part-1",
+		"logs/2024-01/.hidden",
+		"logs/2024-02/part-0",
+		"logs/2024-02/sub/part-0",
+		"logs/2024-03/other",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"/logs/2024-*/part-*", []string{"/logs/2024-01/part-0", "/logs/2024-01/part-1", "/logs/2024-02/part-0"}},
+		{"/logs/*/part-0", []string{"/logs/2023-12/part-0", "/logs/2024-01/part-0", "/logs/2024-02/part-0"}},
+		{"/logs/2024-0[23]/*", []string{"/logs/2024-02/part-0", "/logs/2024-03/other"}},
+		{"/logs/2024-0[23]/*/", []string{"/logs/2024-02/sub/"}},
+		{"/logs/*/missing", nil},
+		{"/nope/*", nil},
+		{"/logs/2024-01/part-0", []string{"/logs/2024-01/part-0"}},
+		{"/**/part-0", []string{
+			"/logs/2023-12/part-0",
+			"/logs/2024-01/part-0",
+			"/logs/2024-02/part-0",
+			"/logs/2024-02/sub/part-0",
+		}},
+		{"/logs/2024-02/**", []string{"/logs/2024-02/part-0", "/logs/2024-02/sub/part-0"}},
+		{"/logs/*/**/part-0", []string{
+			"/logs/2023-12/part-0",
+			"/logs/2024-01/part-0",
+			"/logs/2024-02/part-0",
+			"/logs/2024-02/sub/part-0",
+		}},
+		{"/logs/**/sub/*", []string{"/logs/2024-02/sub/part-0"}},
+		{"/logs/**/*.json", nil},
+	}
+	for _, tc := range testCases {
+		actual, err := Glob(dir + tc.pattern)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.pattern, err)
+		}
+		var expected []string
+		for _, e := range tc.expected {
+			expected = append(expected, dir+e)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc.pattern, expected, actual)
+		}
+	}
+}
+
+func TestGlob_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 2}
+	f.keys = []string{
+		"logs/2024-01/part-0",
+		"logs/2024-01/part-1",
+		"logs/2024-01/part-2",
+		"logs/2024-01/summary",
+		"logs/2024-02/part-0",
+		"logs/2024-02/part?.txt",
+	}
+	setupFakeS3(t, f, "max_candidates = 2\n")
+
+	testCases := []struct {
+		pattern  string
+		expected []string
+	}{
+		{
+			"s3://bucket/logs/2024-*/part-*",
+			[]string{
+				"s3://bucket/logs/2024-01/part-0",
+				"s3://bucket/logs/2024-01/part-1",
+				"s3://bucket/logs/2024-01/part-2",
+				"s3://bucket/logs/2024-02/part-0",
+			},
+		},
+		{"s3://bucket/logs/*/part?*", []string{"s3://bucket/logs/2024-02/part?.txt"}},
+		{"s3://bucket/logs/*/summary", []string{"s3://bucket/logs/2024-01/summary"}},
+		{"s3://bucket/**/*.txt", []string{"s3://bucket/logs/2024-02/part?.txt"}},
+		{"s3://bucket/logs/**/part-[12]", []string{"s3://bucket/logs/2024-01/part-1", "s3://bucket/logs/2024-01/part-2"}},
+	}
+	for _, tc := range testCases {
+		actual, err := Glob(tc.pattern)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.pattern, err)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc.pattern, tc.expected, actual)
+		}
+	}
+}
+
+func TestSuggest_wildcards(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	for _, name := range []string{"a/part-0", "a/part-1", "a/other", "b/part-0"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{dir + "/a/part-0", dir + "/a/part-1", dir + "/b/part-0"}
+	actual, err := Suggest(dir + "/*/part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestSuggest_aliases(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	local := filepath.Join(dir, "data")
+	for _, name := range []string{"alpha.txt", "beta.txt"} {
+		path := filepath.Join(local, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := "[https://example.invalid]\nusername = nobody\n\n[" + local + "/]\nalias = mydata\n"
+	if err := os.WriteFile(filepath.Join(dir, "kot.cfg"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(local); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	testCases := map[string][]string{
+		"":           {"alpha.txt", "beta.txt"},
+		"a":          {"alpha.txt"},
+		local + "/a": {local + "/alpha.txt"},
+		local + "/":  {local + "/alpha.txt", local + "/beta.txt"},
+		"myd":        {local + "/alpha.txt", local + "/beta.txt"},
+	}
+	for tc, expected := range testCases {
+		actual, err := Suggest(tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc, err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+}
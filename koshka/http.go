@@ -1,19 +1,444 @@
 package koshka
 
 import (
-	_ "fmt"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
 )
 
-func http_cat(rawUrl string) error {
-	response, err := http.Get(rawUrl)
+type httpBackend struct{}
+
+func init() {
+	Register("http", httpBackend{})
+	Register("https", httpBackend{})
+}
+
+func (httpBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	return http_open(rawUrl)
+}
+
+func (httpBackend) OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error) {
+	header := http.Header{"Range": {byteRange.header()}}
+	response, err := http_do(http.MethodGet, rawUrl, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		return response.Body, nil
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		response.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	case response.StatusCode >= 400:
+		response.Body.Close()
+		return nil, fmt.Errorf("unable to read from url %q: %s", rawUrl, response.Status)
+	}
+
+	// The server ignored the Range header and sent us everything
+	return sliceReader(response.Body, byteRange)
+}
+
+func (httpBackend) Create(rawUrl string) (io.WriteCloser, error) {
+	return http_create(rawUrl, rawUrl, nil), nil
+}
+
+func (httpBackend) CreateIfMatch(rawUrl string, etag string) (io.WriteCloser, error) {
+	return http_create(rawUrl, rawUrl, http_if_match(etag)), nil
+}
+
+func (httpBackend) Remove(rawUrl string) error {
+	response, err := http_do(http.MethodDelete, rawUrl, nil, nil)
 	if err != nil {
 		return err
 	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unable to remove url %q: %s", rawUrl, response.Status)
+	}
+	return nil
+}
+
+func (httpBackend) Stat(rawUrl string) (ObjectInfo, error) {
+	return http_stat(rawUrl)
+}
+
+func (httpBackend) List(prefix string) ([]string, error) {
+	return http_list(prefix)
+}
+
+func http_open(rawUrl string) (io.ReadCloser, error) {
+	response, err := http_do(http.MethodGet, rawUrl, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		response.Body.Close()
+		return nil, fmt.Errorf("unable to read from url %q: %s", rawUrl, response.Status)
+	}
+	return response.Body, nil
+}
+
+func http_stat(rawUrl string) (ObjectInfo, error) {
+	info := ObjectInfo{Url: rawUrl}
+	response, err := http_do(http.MethodHead, rawUrl, nil, nil)
+	if err != nil {
+		return info, err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return info, fmt.Errorf("unable to stat url %q: %s", rawUrl, response.Status)
+	}
+
+	info.Size = response.ContentLength
+	if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+		info.ModTime, _ = http.ParseTime(lastModified)
+	}
+	info.ETag = response.Header.Get("ETag")
+	info.ContentType = response.Header.Get("Content-Type")
+	info.StorageClass = response.Header.Get("X-Amz-Storage-Class")
+	if info.StorageClass == "" {
+		info.StorageClass = response.Header.Get("X-Goog-Storage-Class")
+	}
+	info.Metadata = http_metadata(response.Header)
+	info.Checksums = http_checksums(response.Header)
+	return info, nil
+}
+
+//
+// Object stores that serve objects over plain HTTP, e.g. S3 and GCS, send
+// the user's metadata as headers with these prefixes
+//
+var httpMetadataPrefixes = []string{"X-Amz-Meta-", "X-Goog-Meta-", "X-Object-Meta-"}
+
+func http_metadata(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for key, values := range header {
+		for _, prefix := range httpMetadataPrefixes {
+			if name, ok := strings.CutPrefix(key, prefix); ok && len(values) > 0 {
+				metadata[strings.ToLower(name)] = values[0]
+			}
+		}
+	}
+	return metadata
+}
+
+//
+// Collect whatever checksums the server sent us, e.g.
+//
+//	Content-MD5: <base64>
+//	Digest: SHA-256=<base64>
+//	Repr-Digest: sha-256=:<base64>:
+//	X-Goog-Hash: crc32c=<base64>,md5=<base64>
+//	X-Amz-Checksum-Sha256: <base64>
+//
+// We don't treat the ETag as an MD5, because most servers make it up some
+// other way.
+//
+func http_checksums(header http.Header) map[string]string {
+	checksums := make(map[string]string)
+	if md5 := header.Get("Content-Md5"); md5 != "" {
+		checksums["md5"] = base64ToHex(md5)
+	}
+	for algorithm, key := range map[string]string{"crc32c": "X-Amz-Checksum-Crc32c", "sha256": "X-Amz-Checksum-Sha256"} {
+		if checksum := header.Get(key); checksum != "" && !strings.Contains(checksum, "-") {
+			checksums[algorithm] = base64ToHex(checksum)
+		}
+	}
+	names := map[string]string{"md5": "md5", "sha-256": "sha256", "crc32c": "crc32c"}
+	for _, key := range []string{"X-Goog-Hash", "Digest", "Repr-Digest"} {
+		for _, value := range header.Values(key) {
+			for _, item := range strings.Split(value, ",") {
+				name, checksum, found := strings.Cut(strings.TrimSpace(item), "=")
+				algorithm, known := names[strings.ToLower(name)]
+				// Only GCS sends CRC32C this way, in base64
+				if !found || !known || (algorithm == "crc32c" && key != "X-Goog-Hash") {
+					continue
+				}
+				checksums[algorithm] = base64ToHex(strings.Trim(checksum, ":"))
+			}
+		}
+	}
+	return checksums
+}
+
+//
+// Upload to requestUrl with PUT, configured from the section of kot.cfg for
+// rawUrl, which differs from requestUrl for e.g. WebDAV.  Closing the writer
+// with an error abandons the request before the body is complete, so the
+// server never gets a truncated object.
+//
+func http_create(requestUrl, rawUrl string, header http.Header) io.WriteCloser {
+	return newUploadWriter(func(reader io.Reader) error {
+		request, err := http.NewRequest(http.MethodPut, requestUrl, reader)
+		if err != nil {
+			return err
+		}
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		client, err := http_configure(request, rawUrl)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return fmt.Errorf("unable to write to url %q: %w", rawUrl, err)
+		}
+		response.Body.Close()
+		switch {
+		case response.StatusCode == http.StatusPreconditionFailed:
+			return fmt.Errorf("unable to write to url %q: %w", rawUrl, ErrObjectChanged)
+		case response.StatusCode >= 400:
+			return fmt.Errorf("unable to write to url %q: %s", rawUrl, response.Status)
+		}
+		return nil
+	})
+}
+
+//
+// The If-Match header for a PUT that only succeeds if the object still has
+// etag.  Weak ETags never match, so we leave those to PutIfUnchanged.
+//
+func http_if_match(etag string) http.Header {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return nil
+	}
+	if !strings.HasPrefix(etag, "\"") {
+		etag = strconv.Quote(etag)
+	}
+	return http.Header{"If-Match": {etag}}
+}
+
+//
+// Send a request, configured from the matching section of kot.cfg, e.g.
+//
+//	[https://example.com]
+//	username = secret
+//	password = nonono
+//	token = bearer-token-instead-of-username-and-password
+//	header.X-Api-Key = extra-header-sent-with-each-request
+//	ca_bundle = /path/to/ca.pem
+//
+func http_do(method, rawUrl string, body io.Reader, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(method, rawUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	client, err := http_configure(request, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+//
+// Apply the configuration for rawUrl to the request, and return the client
+// to send it with
+//
+func http_configure(request *http.Request, rawUrl string) (client *http.Client, err error) {
+	client = http.DefaultClient
+	var custom []string
+	if kotConfig, err := findConfig(rawUrl, ""); err == nil {
+		if username, ok := kotConfig["username"]; ok {
+			request.SetBasicAuth(username, kotConfig["password"])
+		}
+		if token, ok := kotConfig["token"]; ok {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		for key, value := range kotConfig {
+			if name, ok := strings.CutPrefix(key, "header."); ok {
+				request.Header.Set(name, value)
+				custom = append(custom, name)
+			}
+		}
+		if caBundle, ok := kotConfig["ca_bundle"]; ok {
+			if client, err = http_client(caBundle); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(custom) > 0 {
+		client = &http.Client{
+			Transport:     client.Transport,
+			CheckRedirect: http_strip_on_redirect(custom),
+		}
+	}
+	return client, nil
+}
+
+//
+// Go already drops the Authorization header when a redirect leaves the
+// domain, but not the headers from kot.cfg, which are just as likely to be
+// secrets, e.g. X-Api-Key.  Drop them whenever a redirect changes the host.
+//
+func http_strip_on_redirect(names []string) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		// The same limit as the default policy
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if request.URL.Host != via[0].URL.Host {
+			for _, name := range names {
+				request.Header.Del(name)
+			}
+		}
+		return nil
+	}
+}
+
+var (
+	httpClientsMutex sync.Mutex
+	httpClients      = make(map[string]*http.Client)
+)
+
+//
+// Return a client that trusts the certificates in caBundle, in addition to
+// the system ones
+//
+func http_client(caBundle string) (*http.Client, error) {
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+	if client, ok := httpClients[caBundle]; ok {
+		return client, nil
+	}
+
+	pem, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca_bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ca_bundle %q", caBundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	client := &http.Client{Transport: transport}
+	httpClients[caBundle] = client
+	return client, nil
+}
+
+//
+// Complete a URL by fetching the directory listing of its parent, e.g. the
+// autoindex pages served by Apache and nginx.  nginx can also serve these
+// listings as JSON, so we accept that too.
+//
+func http_list(prefix string) (candidates []string, err error) {
+	parsedUrl, err := url.Parse(prefix)
+	if err != nil {
+		return candidates, err
+	}
+	if parsedUrl.Host == "" {
+		return candidates, fmt.Errorf("unable to list url without a host: %q", prefix)
+	}
+	if parsedUrl.Path == "" {
+		prefix += "/"
+	}
+	dirUrl := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	header := http.Header{"Accept": {"text/html, application/json;q=0.9"}}
+	response, err := http_do(http.MethodGet, dirUrl, nil, header)
+	if err != nil {
+		return candidates, err
+	}
 	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		return candidates, fmt.Errorf("unable to list url %q: %s", dirUrl, response.Status)
+	}
 
-	io.Copy(os.Stdout, response.Body)
-	return nil
+	var links []string
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		links, err = http_parse_json_listing(response.Body)
+	} else {
+		links, err = http_parse_html_listing(response.Body)
+	}
+	if err != nil {
+		return candidates, fmt.Errorf("unable to parse listing at %q: %w", dirUrl, err)
+	}
+
+	base, _ := url.Parse(dirUrl)
+	seen := make(map[string]bool)
+	for _, link := range links {
+		ref, err := url.Parse(link)
+		if err != nil || ref.RawQuery != "" {
+			// Skip junk and the column sorting links that Apache adds
+			continue
+		}
+		ref.Fragment = ""
+		child := base.ResolveReference(ref).String()
+		if child == dirUrl || !strings.HasPrefix(child, dirUrl) || !strings.HasPrefix(child, prefix) {
+			continue
+		}
+		if !seen[child] {
+			candidates = append(candidates, child)
+			seen[child] = true
+		}
+	}
+	return candidates, nil
+}
+
+func http_parse_html_listing(reader io.Reader) (links []string, err error) {
+	tokenizer := html.NewTokenizer(reader)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return links, nil
+			}
+			return links, tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				if string(key) == "href" {
+					links = append(links, string(value))
+				}
+			}
+		}
+	}
+}
+
+//
+// Parse the output of nginx's "autoindex_format json", which looks like
+// [{"name": "foo", "type": "directory", ...}, {"name": "bar", "type": "file", ...}]
+//
+func http_parse_json_listing(reader io.Reader) (links []string, err error) {
+	var entries []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+		return links, err
+	}
+	for _, entry := range entries {
+		link := url.PathEscape(entry.Name)
+		if entry.Type == "directory" {
+			link += "/"
+		}
+		links = append(links, link)
+	}
+	return links, nil
 }
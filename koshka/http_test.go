@@ -0,0 +1,251 @@
+package koshka
+
+import (
+	"crypto/md5"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+const apacheListing = `<html><body><h1>Index of /pub</h1>
+<a href="?C=N;O=D">Name</a> <a href="?C=M;O=A">Last modified</a>
+<a href="/">Parent Directory</a>
+<a href="release-1.0.tar.gz">release-1.0.tar.gz</a>
+<a href="release-1.1.tar.gz">release-1.1.tar.gz</a>
+<a href="nightly/">nightly/</a>
+<a href="https://elsewhere.example.com/">elsewhere</a>
+</body></html>`
+
+const nginxListing = `[
+{"name": "release-1.0.tar.gz", "type": "file", "size": 10},
+{"name": "nightly", "type": "directory"}
+]`
+
+func setupFakeHttp(t *testing.T, handler http.HandlerFunc, extraConfig string) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cfg := fmt.Sprintf("[%s]\n%s", server.URL, extraConfig)
+	if err := os.WriteFile(filepath.Join(home, "kot.cfg"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func Test_http_list(t *testing.T) {
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/pub/":
+			fmt.Fprint(w, apacheListing)
+		case "/json/":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, nginxListing)
+		default:
+			http.NotFound(w, r)
+		}
+	}, "username = user\npassword = pass\n")
+
+	testCases := map[string][]string{
+		server.URL + "/pub/": {
+			server.URL + "/pub/release-1.0.tar.gz",
+			server.URL + "/pub/release-1.1.tar.gz",
+			server.URL + "/pub/nightly/",
+		},
+		server.URL + "/pub/rel": {
+			server.URL + "/pub/release-1.0.tar.gz",
+			server.URL + "/pub/release-1.1.tar.gz",
+		},
+		server.URL + "/json/": {
+			server.URL + "/json/release-1.0.tar.gz",
+			server.URL + "/json/nightly/",
+		},
+	}
+	for tc, expected := range testCases {
+		actual, err := http_list(tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+}
+
+func Test_http_open(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" || r.Header.Get("X-Api-Key") != "abc" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	t.Cleanup(server.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	caBundle := filepath.Join(home, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := fmt.Sprintf("[%s]\ntoken = sekrit\nheader.X-Api-Key = abc\nca_bundle = %s\n", server.URL, caBundle)
+	if err := os.WriteFile(filepath.Join(home, "kot.cfg"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := http_open(server.URL + "/hello.txt")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	defer reader.Close()
+	actual, _ := io.ReadAll(reader)
+	if string(actual) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", actual)
+	}
+}
+
+func Test_http_open_redirect(t *testing.T) {
+	var received []string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path+" "+r.Header.Get("X-Api-Key"))
+		fmt.Fprint(w, "hello")
+	}))
+	t.Cleanup(elsewhere.Close)
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path+" "+r.Header.Get("X-Api-Key"))
+		switch r.URL.Path {
+		case "/local":
+			http.Redirect(w, r, "/moved", http.StatusFound)
+		case "/moved":
+			fmt.Fprint(w, "hello")
+		default:
+			http.Redirect(w, r, elsewhere.URL+"/hello.txt", http.StatusFound)
+		}
+	}, "header.X-Api-Key = abc\n")
+
+	for _, path := range []string{"/local", "/remote"} {
+		reader, err := http_open(server.URL + path)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", path, err)
+		}
+		reader.Close()
+	}
+	expected := []string{"/local abc", "/moved abc", "/remote abc", "/hello.txt "}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("expected %q, got %q", expected, received)
+	}
+}
+
+func TestPut_http(t *testing.T) {
+	var uploaded []byte
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uploaded, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}, "")
+
+	if err := Put(server.URL+"/upload.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if string(uploaded) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", uploaded)
+	}
+
+	if err := Put(server.URL+"/upload.txt", iotest.ErrReader(io.ErrUnexpectedEOF)); err == nil {
+		t.Errorf("expected a failing reader to fail the upload")
+	}
+}
+
+func TestPutIfUnchanged_http(t *testing.T) {
+	contents := "debug = false"
+	var sneak string
+	var ifMatch []string
+	etag := func() string { return fmt.Sprintf("\"%x\"", md5.Sum([]byte(contents))) }
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+			if match := r.Header.Get("If-Match"); match != "" && match != etag() {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			contents = string(data)
+		default:
+			w.Header().Set("ETag", etag())
+			http.ServeContent(w, r, "app.cfg", time.Time{}, strings.NewReader(contents))
+			// Somebody else writes right after PutIfUnchanged has checked
+			if sneak != "" {
+				contents, sneak = sneak, ""
+			}
+		}
+	}, "")
+	rawUrl := server.URL + "/app.cfg"
+
+	info, err := Stat(rawUrl)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if err := PutIfUnchanged(rawUrl, strings.NewReader("debug = true"), info); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if contents != "debug = true" {
+		t.Errorf("expected %q, got %q", "debug = true", contents)
+	}
+
+	info, _ = Stat(rawUrl)
+	sneak = "debug = sneaky"
+	err = PutIfUnchanged(rawUrl, strings.NewReader("debug = maybe"), info)
+	if !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("expected %q, got %q", ErrObjectChanged, err)
+	}
+	if contents != "debug = sneaky" {
+		t.Errorf("expected %q, got %q", "debug = sneaky", contents)
+	}
+	if len(ifMatch) != 2 || ifMatch[0] == "" || ifMatch[1] == "" {
+		t.Errorf("expected If-Match on every PUT, got %q", ifMatch)
+	}
+}
+
+func Test_http_checksums(t *testing.T) {
+	testCases := []struct {
+		header   http.Header
+		expected map[string]string
+	}{
+		{http.Header{"Content-Md5": {"1B2M2Y8AsgTpgAmY7PhCfg=="}}, map[string]string{"md5": "d41d8cd98f00b204e9800998ecf8427e"}},
+		{
+			http.Header{"X-Goog-Hash": {"crc32c=AAAAAA==,md5=1B2M2Y8AsgTpgAmY7PhCfg=="}},
+			map[string]string{"crc32c": "00000000", "md5": "d41d8cd98f00b204e9800998ecf8427e"},
+		},
+		{
+			http.Header{"Repr-Digest": {"sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:"}},
+			map[string]string{"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{http.Header{"Digest": {"SHA-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=, crc32c=AAAAAA=="}}, map[string]string{"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},
+		{http.Header{"Etag": {"\"d41d8cd98f00b204e9800998ecf8427e\""}}, map[string]string{}},
+	}
+	for _, tc := range testCases {
+		if actual := http_checksums(tc.header); !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("tc: %v expected %q, got %q", tc.header, tc.expected, actual)
+		}
+	}
+}
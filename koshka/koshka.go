@@ -4,26 +4,28 @@ package koshka
 // [x] List S3 objects matching a given prefix
 // [x] Stream a specific S3 object
 // [x] Integrate with autocompletion
-// [ ] Support for S3 versions
+// [x] Support for S3 versions
 // [x] Support for aliases
 // [.] Handle HTTP/S
-// [ ] Handle local files
-// [ ] Any other backends?
+// [x] Handle local files
+// [x] Any other backends?  SFTP, WebDAV
 // [.] Tests!!
-// [ ] GNU cat-compatible command-line flags
+// [x] GNU cat-compatible command-line flags
 // [ ] Proper packaging
 // [ ] CI to build binaries for MacOS, Windows and Linux
 
 // [x] Where's the AWS SDK golang reference?  https://pkg.go.dev/github.com/aws/aws-sdk-go-v2
-// [ ] How to package this thing without having to build separate binaries for kot, kedit, etc?
+// [x] How to package this thing without having to build separate binaries for kot, kedit, etc?
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"net/url"
+	"io/fs"
 	"os"
 	"strings"
+	"sync"
 )
 
 func Suggest(prefix string) (candidates []string, err error) {
@@ -33,74 +35,261 @@ func Suggest(prefix string) (candidates []string, err error) {
 	//
 	prependScheme := false
 	sections, err := LoadConfig("")
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return []string{}, err
 	}
 	for _, section := range sections {
-		if strings.HasPrefix(section.items["alias"], prefix) {
+		//
+		// An empty prefix is a prefix of every alias, and a section without
+		// an alias matches any prefix at all, so neither counts as a hit
+		//
+		alias := section.items["alias"]
+		if prefix != "" && alias != "" && strings.HasPrefix(alias, prefix) {
 			prefix = section.name
 			prependScheme = true
 			break
 		}
 	}
 
-	parsedUrl, err := url.Parse(prefix)
+	backend, err := backendFor(prefix)
 	if err != nil {
 		return []string{}, err
 	}
 
-	//
-	// TODO: parse HTTP directory listings for autocompletion
-	//
-	if parsedUrl.Scheme == "s3" {
-		candidates, err = s3_list(prefix)
+	if hasWildcards(prefix) {
+		//
+		// Offer the matches of the pattern so far, e.g. logs/*/part expands
+		// to the same as logs/*/part*
+		//
+		pattern := prefix
+		if !strings.HasSuffix(pattern, "*") {
+			pattern += "*"
+		}
+		candidates, err = Glob(pattern)
 	} else {
-		return []string{}, errors.New(fmt.Sprintf("unsupported scheme: %s", parsedUrl.Scheme))
+		candidates, err = cachedList(backend, prefix)
 	}
-
 	if err != nil {
 		return []string{}, err
 	}
-	if prependScheme {
+
+	//
+	// Drill down as far as possible
+	//
+	for len(candidates) == 1 && strings.HasSuffix(candidates[0], "/") && candidates[0] != prefix {
+		prefix = candidates[0]
+		children, err := cachedList(backend, prefix)
+		if err != nil || len(children) == 0 {
+			break
+		}
+		candidates = children
+	}
+
+	//
+	// FIXME: why _must_ we include the //bucket, but exclude the s3: part?
+	// Is colon some sort of special character for the autocompletion engine?
+	//
+	if scheme := schemeOf(prefix); scheme != "" && !prependScheme {
 		for i := range candidates {
-			candidates[i] = fmt.Sprintf("%s:%s", parsedUrl.Scheme, candidates[i])
+			candidates[i] = strings.TrimPrefix(candidates[i], scheme+":")
 		}
 	}
 	return candidates, nil
 }
 
-func vanillaCat(path string) error {
-	fin, err := os.Open(path)
+type CatOptions struct {
+	// Raw disables transparent decompression
+	Raw bool
+	// Range selects part of the object as stored, e.g. the first 100 bytes.
+	// Byte ranges of compressed data are meaningless, so Range implies Raw.
+	Range *ByteRange
+	// HeadLines and TailLines select the first or last lines of the object
+	HeadLines int
+	TailLines int
+	// Pretty renders JSON, CSV, Parquet and Avro for humans, see prettify.
+	// HeadLines and TailLines then apply to the rendered lines.  Pretty has
+	// no effect with Raw or Range.
+	Pretty bool
+	// Verify checks the object against the checksums that the backend
+	// stored along with it, see ErrChecksumMismatch.  This reads the entire
+	// object, even if HeadLines stops the output short.
+	Verify bool
+}
+
+func Cat(rawUrl string) error {
+	return CatWithOptions(os.Stdout, rawUrl, CatOptions{})
+}
+
+func CatWithOptions(writer io.Writer, rawUrl string, options CatOptions) error {
+	var verifier *checksumVerifier
+	if options.Verify {
+		if rawUrl == "-" || options.Range != nil {
+			return fmt.Errorf("unable to verify url %q: checksums cover whole stored objects only", rawUrl)
+		}
+		var err error
+		if verifier, err = newChecksumVerifier(rawUrl); err != nil {
+			return err
+		}
+	}
+
+	isPretty := options.Pretty && !options.Raw && options.Range == nil
+	if options.TailLines > 0 && rawUrl != "-" && options.Range == nil && !isPretty && verifier == nil {
+		compressed, err := isCompressed(rawUrl)
+		if err != nil {
+			return err
+		}
+		if options.Raw || !compressed {
+			return tailLines(writer, rawUrl, options.TailLines)
+		}
+	}
+
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case rawUrl == "-":
+		reader = io.NopCloser(os.Stdin)
+		if options.Range != nil {
+			reader, err = sliceReader(reader, *options.Range)
+		}
+	case options.Range != nil:
+		reader, err = OpenRange(rawUrl, *options.Range)
+	default:
+		reader, err = Open(rawUrl)
+	}
 	if err != nil {
 		return err
 	}
-	defer fin.Close()
+	if verifier != nil {
+		reader = verifier.wrap(reader)
+	}
+	defer reader.Close()
 
-	if _, err := io.Copy(os.Stdout, fin); err != nil {
-		return err
+	if !options.Raw && options.Range == nil {
+		decompressed, err := decompress(reader)
+		if err != nil {
+			return fmt.Errorf("unable to decompress url %q: %w", rawUrl, err)
+		}
+		defer decompressed.Close()
+		reader = decompressed
 	}
 
+	var source io.Reader = reader
+	var pretty io.ReadCloser
+	if isPretty {
+		pretty = prettify(reader, rawUrl)
+		defer pretty.Close()
+		source = pretty
+	}
+	if options.HeadLines > 0 {
+		source = &lineLimitedReader{reader: source, remaining: options.HeadLines}
+	}
+	if options.TailLines > 0 {
+		err = tailStream(writer, source, options.TailLines)
+	} else {
+		_, err = io.Copy(writer, source)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read stream from url %q: %w", rawUrl, err)
+	}
+
+	if verifier != nil {
+		// Make sure that nothing else is still reading, before reading the rest
+		if pretty != nil {
+			pretty.Close()
+		}
+		return verifier.verify()
+	}
 	return nil
 }
 
-func Cat(rawUrl string) error {
-	if rawUrl == "-" {
-		_, err := io.Copy(os.Stdout, os.Stdin)
-		return err
+//
+// CatAll writes the objects at rawUrls to writer, in order, like calling
+// CatWithOptions for each of them in turn.  Up to workers objects get fetched
+// at the same time, so that many small objects don't take as long as fetching
+// them one by one.  The object whose turn it is streams straight to writer,
+// and only the ones fetched ahead of it get buffered in memory.
+//
+func CatAll(writer io.Writer, rawUrls []string, options CatOptions, workers int) error {
+	sequential := workers <= 1 || len(rawUrls) <= 1
+	for _, rawUrl := range rawUrls {
+		// Reading standard input ahead would only buffer it for no benefit
+		if rawUrl == "-" {
+			sequential = true
+		}
 	}
-	parsedUrl, err := url.Parse(rawUrl)
-	if err != nil {
-		return err
+	if sequential {
+		for _, rawUrl := range rawUrls {
+			if err := CatWithOptions(writer, rawUrl, options); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writers := make([]*orderedWriter, len(rawUrls))
+	results := make([]chan error, len(rawUrls))
+	for i := range rawUrls {
+		writers[i] = &orderedWriter{}
+		results[i] = make(chan error, 1)
 	}
-	switch parsedUrl.Scheme {
-	case "":
-		return vanillaCat(rawUrl)
-	case "s3":
-		return s3_cat(rawUrl)
-	case "http":
-		fallthrough
-	case "https":
-		return http_cat(rawUrl)
-	}
-	return fmt.Errorf("cat functionality for scheme %s not implemented yet", parsedUrl.Scheme)
+
+	//
+	// Only fetch up to workers objects ahead of the one we're writing, so a
+	// slow object doesn't make us buffer everything after it
+	//
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, rawUrl := range rawUrls {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, rawUrl string) {
+				results[i] <- CatWithOptions(writers[i], rawUrl, options)
+			}(i, rawUrl)
+		}
+	}()
+
+	for i := range rawUrls {
+		if err := writers[i].start(writer); err != nil {
+			return err
+		}
+		err := <-results[i]
+		<-slots
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Buffers what an object fetched ahead writes, until start, when it writes
+// out the buffer and passes everything after that straight through
+//
+type orderedWriter struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+	writer io.Writer
+}
+
+func (w *orderedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.writer != nil {
+		return w.writer.Write(p)
+	}
+	return w.buffer.Write(p)
+}
+
+func (w *orderedWriter) start(writer io.Writer) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writer = writer
+	_, err := writer.Write(w.buffer.Bytes())
+	w.buffer = bytes.Buffer{}
+	return err
 }
@@ -1,15 +1,289 @@
 package koshka
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
-func Test_s3_split(t *testing.T) {
-	bucket, key := s3_split("s3://bucket/key")
-	if bucket != "bucket" {
-		t.Errorf("expected bucket, got %q", bucket)
+func Test_parseS3Url(t *testing.T) {
+	testCases := map[string]s3Url{
+		"s3://bucket/key":                  {Bucket: "bucket", Key: "key"},
+		"s3://bucket":                      {Bucket: "bucket"},
+		"s3:":                              {},
+		"s3://bucket/with space.txt":       {Bucket: "bucket", Key: "with space.txt"},
+		"s3://bucket/100%.txt":             {Bucket: "bucket", Key: "100%.txt"},
+		"s3://bucket/a%3Fb":                {Bucket: "bucket", Key: "a?b"},
+		"s3://bucket/what?":                {Bucket: "bucket", Key: "what?"},
+		"s3://bucket/q?a=b":                {Bucket: "bucket", Key: "q?a=b"},
+		"s3://bucket/key?versionId=abc.1":  {Bucket: "bucket", Key: "key", VersionId: "abc.1", Query: url.Values{"versionId": {"abc.1"}}},
+		"s3://bucket/a?b?versionId=abc":    {Bucket: "bucket", Key: "a?b", VersionId: "abc", Query: url.Values{"versionId": {"abc"}}},
+		"s3://bucket/a%253F?versionId=abc": {Bucket: "bucket", Key: "a%3F", VersionId: "abc", Query: url.Values{"versionId": {"abc"}}},
 	}
-	if key != "key" {
-		t.Errorf("expected key, got %q", key)
+	for tc, expected := range testCases {
+		actual, err := parseS3Url(tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("tc: %q expected %+v, got %+v", tc, expected, actual)
+		}
+
+		roundTrip, err := parseS3Url(actual.String())
+		if err != nil || !reflect.DeepEqual(roundTrip, actual) {
+			t.Errorf("tc: %q did not survive a round trip via %q", tc, actual.String())
+		}
+	}
+
+	for _, tc := range []string{"https://bucket/key", "s3://bu cket/key", "s3:bucket/key"} {
+		if _, err := parseS3Url(tc); err == nil {
+			t.Errorf("tc: %q expected an error", tc)
+		}
+	}
+}
+
+func Test_schemeOf(t *testing.T) {
+	testCases := map[string]string{
+		"s3://bucket/key":          "s3",
+		"HTTPS://example.com":      "https",
+		"file:///etc/hosts":        "file",
+		"/etc/hosts":               "",
+		"relative/path:with:colon": "",
+		"C:\\Windows":              "",
+		"":                         "",
+	}
+	for tc, expected := range testCases {
+		if actual := schemeOf(tc); actual != expected {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+}
+
+func Test_local_list(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.txt", "alpine/x", ".hidden", "beta"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte{}, 0o644)
+	}
+
+	testCases := map[string][]string{
+		dir + "/al":            {dir + "/alpha.txt", dir + "/alpine/"},
+		dir + "/":              {dir + "/alpha.txt", dir + "/alpine/", dir + "/beta"},
+		dir + "/.":             {dir + "/.hidden"},
+		"file://" + dir + "/b": {"file://" + dir + "/beta"},
+	}
+	for tc, expected := range testCases {
+		actual, err := local_list(tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+}
+
+func TestCopy_local(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, ".cache"))
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		dst      string
+		expected string
+	}{
+		{filepath.Join(dir, "dst.txt"), filepath.Join(dir, "dst.txt")},
+		{filepath.Join(dir, "sub") + "/", filepath.Join(dir, "sub", "src.txt")},
+		{filepath.Join(dir, "sub"), filepath.Join(dir, "sub", "src.txt")},
+		{"file://" + filepath.Join(dir, "url.txt"), filepath.Join(dir, "url.txt")},
+	}
+	for _, tc := range testCases {
+		if err := Copy(src, tc.dst); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.dst, err)
+		}
+		actual, err := os.ReadFile(tc.expected)
+		if err != nil || string(actual) != "hello\n" {
+			t.Errorf("tc: %q expected %q, got %q (%v)", tc.dst, "hello\n", actual, err)
+		}
+		if err := Remove(tc.expected); err != nil {
+			t.Errorf("tc: %q unexpected error %v", tc.dst, err)
+		}
+		if _, err := os.Stat(tc.expected); !os.IsNotExist(err) {
+			t.Errorf("tc: %q expected %q to be removed", tc.dst, tc.expected)
+		}
+	}
+}
+
+func TestCreate_local(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, ".cache"))
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("original\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changes until the writer is closed, and aborting leaves no trace
+	writer, err := Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	fmt.Fprint(writer, "partial")
+	if actual, _ := os.ReadFile(path); string(actual) != "original\n" {
+		t.Errorf("expected %q while writing, got %q", "original\n", actual)
+	}
+	writer.(interface{ CloseWithError(error) error }).CloseWithError(io.ErrUnexpectedEOF)
+	if actual, _ := os.ReadFile(path); string(actual) != "original\n" {
+		t.Errorf("expected %q after aborting, got %q", "original\n", actual)
+	}
+
+	if err := Put(path, strings.NewReader("replaced\n")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if actual, _ := os.ReadFile(path); string(actual) != "replaced\n" {
+		t.Errorf("expected %q, got %q", "replaced\n", actual)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the mode to be kept, got %v", info.Mode())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".notes.txt.kot-*")); len(matches) > 0 {
+		t.Errorf("expected no temporary files to be left behind, got %q", matches)
+	}
+
+	for _, dst := range []string{path, "file://" + path, dir + "/", filepath.Join(dir, ".", "notes.txt")} {
+		if err := Copy(path, dst); err == nil {
+			t.Errorf("tc: %q expected an error copying a file onto itself", dst)
+		}
+	}
+	if actual, _ := os.ReadFile(path); string(actual) != "replaced\n" {
+		t.Errorf("expected %q, got %q", "replaced\n", actual)
+	}
+}
+
+//
+// Serves the path of the URL as its contents, after a delay given by the host,
+// e.g. slow://30/a takes 30ms
+//
+type slowBackend struct {
+	localBackend
+}
+
+func (slowBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	delay, err := strconv.Atoi(parsedUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Duration(delay) * time.Millisecond)
+	if parsedUrl.Path == "/missing" {
+		return nil, fmt.Errorf("no such object: %q", rawUrl)
+	}
+	return io.NopCloser(strings.NewReader(parsedUrl.Path + "\n")), nil
+}
+
+func TestCatAll(t *testing.T) {
+	Register("slow", slowBackend{})
+
+	testCases := []struct {
+		rawUrls     []string
+		workers     int
+		expected    string
+		expectError bool
+	}{
+		{[]string{"slow://30/a", "slow://0/b", "slow://10/c"}, 3, "/a\n/b\n/c\n", false},
+		{[]string{"slow://30/a", "slow://0/b", "slow://10/c"}, 1, "/a\n/b\n/c\n", false},
+		{[]string{"slow://20/a", "slow://0/b", "slow://0/c", "slow://0/d"}, 2, "/a\n/b\n/c\n/d\n", false},
+		{[]string{"slow://0/a", "slow://10/missing", "slow://0/c"}, 3, "/a\n", true},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		err := CatAll(&buffer, tc.rawUrls, CatOptions{}, tc.workers)
+		if (err != nil) != tc.expectError {
+			t.Errorf("tc: %q unexpected error %v", tc.rawUrls, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.rawUrls, tc.expected, actual)
+		}
+	}
+}
+
+//
+// Serves a first line straight away, and the rest only once released
+//
+type streamBackend struct {
+	localBackend
+	release chan struct{}
+}
+
+func (b streamBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	rest := iotest.OneByteReader(blockingReader{b.release, strings.NewReader("rest\n")})
+	return io.NopCloser(io.MultiReader(strings.NewReader("first line\n"), rest)), nil
+}
+
+type blockingReader struct {
+	release chan struct{}
+	reader  io.Reader
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.release
+	return r.reader.Read(p)
+}
+
+//
+// Signals once anything has been written to it
+//
+type signallingWriter struct {
+	bytes.Buffer
+	written chan struct{}
+}
+
+func (w *signallingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.written <- struct{}{}:
+	default:
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestCatAll_streaming(t *testing.T) {
+	release := make(chan struct{})
+	Register("slow", slowBackend{})
+	Register("stream", streamBackend{release: release})
+
+	writer := &signallingWriter{written: make(chan struct{}, 1)}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- CatAll(writer, []string{"stream://a", "slow://0/b"}, CatOptions{}, 2)
+	}()
+	select {
+	case <-writer.written:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the first object to stream before it was complete")
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if actual, expected := writer.String(), "first line\nrest\n/b\n"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
 	}
 }
@@ -0,0 +1,286 @@
+package koshka
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localBackend struct{}
+
+func init() {
+	Register("", localBackend{})
+	Register("file", localBackend{})
+}
+
+//
+// Convert a plain path or a file:// URL to a path on the local filesystem
+//
+func local_path(rawUrl string) (string, error) {
+	if schemeOf(rawUrl) == "" {
+		return rawUrl, nil
+	}
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if parsedUrl.Host != "" && parsedUrl.Host != "localhost" {
+		return "", fmt.Errorf("not a local file: %s", rawUrl)
+	}
+	return parsedUrl.Path, nil
+}
+
+func (localBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	path, err := local_path(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (localBackend) OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error) {
+	path, err := local_path(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	fin, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(fin, byteRange)
+}
+
+func (localBackend) Create(rawUrl string) (io.WriteCloser, error) {
+	path, err := local_path(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return newLocalWriter(path)
+}
+
+//
+// Write to a temporary file next to path, and only replace path with it once
+// we're done, so that a failed write doesn't leave a truncated file behind.
+// Renaming within the same directory is atomic, so readers of path see
+// either the old contents or the new ones.
+//
+type localWriter struct {
+	*os.File
+	path string
+}
+
+func newLocalWriter(path string) (*localWriter, error) {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".kot-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files that only we can read, unlike os.Create
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := temp.Chmod(mode); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return nil, err
+	}
+	return &localWriter{temp, path}, nil
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return nil
+}
+
+//
+// Abandon the write, leaving whatever was at path before untouched
+//
+func (w *localWriter) CloseWithError(err error) error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+func (localBackend) Remove(rawUrl string) error {
+	path, err := local_path(rawUrl)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (localBackend) Stat(rawUrl string) (ObjectInfo, error) {
+	path, err := local_path(rawUrl)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	objectInfo := ObjectInfo{
+		Url:     rawUrl,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+	if !info.IsDir() {
+		objectInfo.ContentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	return objectInfo, nil
+}
+
+func (localBackend) List(prefix string) ([]string, error) {
+	return local_list(prefix)
+}
+
+func (localBackend) Walk(prefix string) ([]string, error) {
+	return local_walk(prefix)
+}
+
+//
+// List the directory entries that begin with prefix.  The candidates keep
+// the form of the prefix, so that they still match what the user has typed:
+// file:// URLs stay URLs, and a leading ~ is expanded for the purposes of
+// listing only.  Hidden files are only offered once the user has typed the
+// leading dot.
+//
+func local_list(prefix string) (candidates []string, err error) {
+	if prefix == "~" {
+		return []string{"~/"}, nil
+	}
+
+	//
+	// Split the prefix into the part that the user typed verbatim, and the
+	// part that corresponds to the local path.
+	//
+	typed, path := "", prefix
+	if schemeOf(prefix) == "file" {
+		if path, err = local_path(prefix); err != nil {
+			return candidates, err
+		}
+		if !strings.HasSuffix(prefix, path) {
+			return candidates, fmt.Errorf("unable to complete url %q", prefix)
+		}
+		typed = strings.TrimSuffix(prefix, path)
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	dir, base := "", path
+	if slash := strings.LastIndex(path, "/"); slash >= 0 {
+		dir, base = path[:slash+1], path[slash+1:]
+	}
+
+	listDir := dir
+	if strings.HasPrefix(listDir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return candidates, err
+		}
+		listDir = filepath.Join(home, listDir[2:])
+	} else if listDir == "" {
+		listDir = "."
+	}
+
+	entries, err := os.ReadDir(listDir)
+	if err != nil {
+		return candidates, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		candidate := typed + dir + name
+		if isDir(listDir, entry) {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+//
+// Like local_list, but descend into the directories too.  Unlike local_list,
+// don't follow symbolic links to directories, to avoid going round in circles.
+//
+func local_walk(prefix string) (objects []string, err error) {
+	candidates, err := local_list(prefix)
+	if err != nil {
+		return objects, err
+	}
+	for _, candidate := range candidates {
+		if !strings.HasSuffix(candidate, "/") {
+			objects = append(objects, candidate)
+			continue
+		}
+
+		root, err := local_path(candidate)
+		if err != nil {
+			return objects, err
+		}
+		if strings.HasPrefix(root, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return objects, err
+			}
+			root = filepath.Join(home, root[2:])
+		}
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			if strings.HasPrefix(entry.Name(), ".") {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || isDir(filepath.Dir(path), entry) {
+				return nil
+			}
+			relative, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, candidate+filepath.ToSlash(relative))
+			return nil
+		})
+		if err != nil {
+			return objects, err
+		}
+	}
+	return objects, nil
+}
+
+//
+// Follow symlinks, so that links to directories complete like directories
+//
+func isDir(dir string, entry fs.DirEntry) bool {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return entry.IsDir()
+	}
+	info, err := os.Stat(filepath.Join(dir, entry.Name()))
+	return err == nil && info.IsDir()
+}
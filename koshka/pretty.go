@@ -0,0 +1,309 @@
+package koshka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+//
+// Render the decompressed contents of the object at rawUrl for humans, in a
+// goroutine, so that the output streams through the returned reader as we go
+// instead of all at the end.  JSON gets indented, CSV gets lined up in
+// columns, and Parquet and Avro files get summarized by their schema.
+// Anything else passes through as is.  Working out which is which happens in
+// the goroutine too, so that e.g. waiting for standard input doesn't block
+// the caller.  Closing the reader stops rendering, and waits for the
+// goroutine to finish with reader.
+//
+func prettify(reader io.Reader, rawUrl string) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		contentType := ""
+		if rawUrl != "-" {
+			// The content type is only a hint, so carry on without it
+			if info, err := Stat(rawUrl); err == nil {
+				contentType = info.ContentType
+			}
+		}
+		// Peeking may wait for input, e.g. from a terminal, so do it here
+		// rather than before anybody starts reading
+		buffered := bufio.NewReader(reader)
+		header, _ := buffered.Peek(512)
+
+		var err error
+		switch prettyFormat(rawUrl, contentType, header) {
+		case "json":
+			err = prettyJson(pipeWriter, buffered)
+		case "csv":
+			err = prettyCsv(pipeWriter, buffered)
+		case "parquet":
+			err = parquetSummary(pipeWriter, rawUrl)
+		case "avro":
+			err = avroSummary(pipeWriter, buffered)
+		default:
+			_, err = io.Copy(pipeWriter, buffered)
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return prettyReader{pipeReader, done}
+}
+
+type prettyReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (p prettyReader) Close() error {
+	err := p.PipeReader.Close()
+	<-p.done
+	return err
+}
+
+//
+// Work out the format of an object from its content type, or failing that,
+// its extension, or failing that, the first few bytes of its contents
+//
+func prettyFormat(rawUrl, contentType string, header []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines", "text/json":
+		return "json"
+	case "text/csv", "application/csv":
+		return "csv"
+	case "application/vnd.apache.parquet", "application/x-parquet", "application/parquet":
+		return "parquet"
+	case "application/avro", "application/x-avro", "avro/binary":
+		return "avro"
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return "json"
+	}
+
+	// Generic content types like binary/octet-stream tell us nothing
+	switch dataExtension(rawUrl) {
+	case ".json", ".jsonl", ".ndjson", ".geojson":
+		return "json"
+	case ".csv":
+		return "csv"
+	case ".parquet":
+		return "parquet"
+	case ".avro":
+		return "avro"
+	}
+
+	switch trimmed := bytes.TrimLeft(header, " \t\r\n"); {
+	case bytes.HasPrefix(header, []byte("PAR1")):
+		return "parquet"
+	case bytes.HasPrefix(header, []byte("Obj\x01")):
+		return "avro"
+	case bytes.HasPrefix(trimmed, []byte("{")), bytes.HasPrefix(trimmed, []byte("[")):
+		return "json"
+	}
+	return ""
+}
+
+//
+// The extension of the object at rawUrl, in lower case, without any
+// compression extension, e.g. ".csv" for s3://bucket/data.CSV.gz
+//
+func dataExtension(rawUrl string) string {
+	name := strings.ToLower(rawUrl)
+	if i := strings.IndexAny(name, "?#"); i >= 0 && schemeOf(rawUrl) != "" {
+		name = name[:i]
+	}
+	for _, extension := range []string{".gz", ".bz2", ".zst", ".xz"} {
+		name = strings.TrimSuffix(name, extension)
+	}
+	return path.Ext(name)
+}
+
+//
+// Indent JSON as it streams through, one byte at a time, so that even a huge
+// array doesn't need to fit in memory.  Consecutive values, e.g. JSON lines,
+// come out one after another.
+//
+func prettyJson(writer io.Writer, reader io.Reader) error {
+	in := bufio.NewReader(reader)
+	out := bufio.NewWriter(writer)
+	defer out.Flush()
+
+	newline := func(depth int) {
+		out.WriteByte('\n')
+		for i := 0; i < depth; i++ {
+			out.WriteString("  ")
+		}
+	}
+	skipSpace := func() {
+		for {
+			next, err := in.Peek(1)
+			if err != nil || !isJsonSpace(next[0]) {
+				return
+			}
+			in.ReadByte()
+		}
+	}
+
+	depth := 0
+	inString, escaped := false, false
+	// Whether we're in the middle of a top-level value that isn't an object or array
+	inScalar := false
+	for {
+		// Stop once nobody's reading any more, rather than reading to the end
+		if _, err := out.Write(nil); err != nil {
+			return err
+		}
+		c, err := in.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if inString {
+			out.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			if depth == 0 && inScalar {
+				out.WriteByte('\n')
+				inScalar = false
+			}
+		case '{', '[':
+			closing := byte('}')
+			if c == '[' {
+				closing = ']'
+			}
+			skipSpace()
+			// Keep empty objects and arrays on one line
+			if next, err := in.Peek(1); err == nil && next[0] == closing {
+				in.ReadByte()
+				out.Write([]byte{c, closing})
+				if depth == 0 {
+					out.WriteByte('\n')
+				}
+				continue
+			}
+			out.WriteByte(c)
+			depth++
+			newline(depth)
+		case '}', ']':
+			if depth == 0 {
+				return fmt.Errorf("malformed JSON: unexpected %q", c)
+			}
+			depth--
+			newline(depth)
+			out.WriteByte(c)
+			if depth == 0 {
+				out.WriteByte('\n')
+			}
+		case ',':
+			out.WriteByte(c)
+			skipSpace()
+			newline(depth)
+		case ':':
+			out.WriteString(": ")
+		default:
+			if c == '"' {
+				inString = true
+			}
+			if depth == 0 {
+				inScalar = true
+			}
+			out.WriteByte(c)
+		}
+	}
+	if inScalar {
+		out.WriteByte('\n')
+	}
+	return nil
+}
+
+func isJsonSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+//
+// How many rows of CSV to line up at a time
+//
+const prettyCsvBatch = 1000
+
+// Keep each row on a single line
+var lineBreaks = strings.NewReplacer("\r", "\\r", "\n", "\\n")
+
+//
+// Line up the columns of CSV.  We only hold a batch of rows in memory at a
+// time, so the columns may get wider from one batch to the next, but never
+// narrower.
+//
+func prettyCsv(writer io.Writer, reader io.Reader) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	out := bufio.NewWriter(writer)
+	defer out.Flush()
+
+	var widths []int
+	var batch [][]string
+	flush := func() error {
+		for _, row := range batch {
+			var line strings.Builder
+			for i, cell := range row {
+				line.WriteString(cell)
+				if i < len(row)-1 {
+					line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+				}
+			}
+			out.WriteString(strings.TrimRight(line.String(), " "))
+			out.WriteByte('\n')
+		}
+		batch = batch[:0]
+		_, err := out.Write(nil)
+		return err
+	}
+
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			flush()
+			return err
+		}
+		for i, cell := range row {
+			cell = lineBreaks.Replace(cell)
+			row[i] = cell
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if width := utf8.RuneCountInString(cell); width > widths[i] {
+				widths[i] = width
+			}
+		}
+		batch = append(batch, row)
+		if len(batch) == prettyCsvBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
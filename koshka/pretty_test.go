@@ -0,0 +1,127 @@
+package koshka
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_prettyJson(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`{"a":1,"b":[true,null],"c":{}}`, "{\n  \"a\": 1,\n  \"b\": [\n    true,\n    null\n  ],\n  \"c\": {}\n}\n"},
+		{"{\"a\": \"x, {y}: \\\"z\\\"\"}\n{\"a\": [ ]}\n", "{\n  \"a\": \"x, {y}: \\\"z\\\"\"\n}\n{\n  \"a\": []\n}\n"},
+		{"1\n\"two\"\n[3]", "1\n\"two\"\n[\n  3\n]\n"},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		if err := prettyJson(&buffer, strings.NewReader(tc.input)); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.input, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.input, tc.expected, actual)
+		}
+	}
+
+	if err := prettyJson(&bytes.Buffer{}, strings.NewReader("{}}")); err == nil {
+		t.Errorf("expected an error for malformed JSON")
+	}
+}
+
+func Test_prettyCsv(t *testing.T) {
+	input := "name,city,notes\nAlice,Sydney,\"two\nlines\"\nBob,Melbourne\n"
+	expected := "name   city       notes\nAlice  Sydney     two\\nlines\nBob    Melbourne\n"
+	var buffer bytes.Buffer
+	if err := prettyCsv(&buffer, strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if actual := buffer.String(); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func Test_prettyFormat(t *testing.T) {
+	testCases := []struct {
+		rawUrl      string
+		contentType string
+		header      string
+		expected    string
+	}{
+		{"s3://bucket/data", "application/json; charset=utf-8", "", "json"},
+		{"s3://bucket/data", "application/geo+json", "", "json"},
+		{"https://example.com/export?format=csv", "text/csv", "", "csv"},
+		{"s3://bucket/part-0000.snappy.parquet", "binary/octet-stream", "", "parquet"},
+		{"s3://bucket/events.ndjson.gz", "", "", "json"},
+		{"s3://bucket/users.AVRO", "", "", "avro"},
+		{"-", "", "PAR1\x15\x04", "parquet"},
+		{"-", "", "  [1, 2]", "json"},
+		{"notes.txt", "text/plain", "hello", ""},
+	}
+	for _, tc := range testCases {
+		if actual := prettyFormat(tc.rawUrl, tc.contentType, []byte(tc.header)); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.rawUrl, tc.expected, actual)
+		}
+	}
+}
+
+func TestCatWithOptions_pretty(t *testing.T) {
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"ok","items":[1,2]}`)
+	}, "")
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "people.csv")
+	os.WriteFile(csvPath, []byte(peopleCsv), 0o644)
+
+	testCases := []struct {
+		rawUrl   string
+		options  CatOptions
+		expected string
+	}{
+		{server.URL + "/status", CatOptions{Pretty: true}, "{\n  \"status\": \"ok\",\n  \"items\": [\n    1,\n    2\n  ]\n}\n"},
+		{server.URL + "/status", CatOptions{Pretty: true, HeadLines: 2}, "{\n  \"status\": \"ok\",\n"},
+		{server.URL + "/status", CatOptions{Pretty: true, Raw: true}, `{"status":"ok","items":[1,2]}`},
+		{csvPath, CatOptions{Pretty: true, TailLines: 1}, "Carol  41   Sydney\n"},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		if err := CatWithOptions(&buffer, tc.rawUrl, tc.options); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.rawUrl, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.rawUrl, tc.expected, actual)
+		}
+	}
+}
+
+func Test_prettify_waitingForInput(t *testing.T) {
+	release := make(chan struct{})
+	returned := make(chan io.ReadCloser, 1)
+	go func() {
+		returned <- prettify(blockingReader{release, strings.NewReader(`{"a":1}`)}, "-")
+	}()
+
+	var pretty io.ReadCloser
+	select {
+	case pretty = <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected prettify to return before any input arrived")
+	}
+	close(release)
+	actual, err := io.ReadAll(pretty)
+	pretty.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if expected := "{\n  \"a\": 1\n}\n"; string(actual) != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,350 @@
+package koshka
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type QueryOptions struct {
+	// InputFormat is "csv" or "json", for JSON lines.  By default, we guess
+	// from the extension of the URL, e.g. data.csv.gz is CSV.
+	InputFormat string
+	// OutputFormat is "csv" or "json", and defaults to InputFormat
+	OutputFormat string
+	// NoHeader means that the first line of CSV input is data, so that the
+	// columns only have positional names: _1, _2, etc.
+	NoHeader bool
+}
+
+//
+// Query runs an S3 Select query, e.g. SELECT s.name FROM S3Object s WHERE
+// CAST(s.age AS INT) > 30, against the CSV or JSON lines object at rawUrl,
+// and writes the matching records to writer.  S3 evaluates the query on the server where it
+// can, and for everything else, e.g. local files, HTTP, or S3-compatible
+// servers without S3 Select, we fetch the object and evaluate the query
+// ourselves.  We only understand a subset of the SQL that S3 does, see
+// sqlQuery, and we're more lenient about comparing CSV fields with numbers,
+// see sqlCompare.
+//
+func Query(writer io.Writer, rawUrl string, sql string, options QueryOptions) error {
+	if options.InputFormat == "" {
+		options.InputFormat = guessQueryFormat(rawUrl)
+	}
+	if options.InputFormat != "csv" && options.InputFormat != "json" {
+		return fmt.Errorf("unable to query url %q: unknown input format, expected csv or json", rawUrl)
+	}
+	if options.OutputFormat == "" {
+		options.OutputFormat = options.InputFormat
+	}
+	if options.OutputFormat != "csv" && options.OutputFormat != "json" {
+		return fmt.Errorf("unknown output format %q, expected csv or json", options.OutputFormat)
+	}
+
+	if schemeOf(rawUrl) == "s3" {
+		err := s3_select(writer, rawUrl, sql, options)
+		if !errors.Is(err, errSelectUnsupported) {
+			return err
+		}
+	}
+
+	query, err := parseSql(sql)
+	if err != nil {
+		return fmt.Errorf("unable to parse query %q: %w", sql, err)
+	}
+
+	var reader io.ReadCloser
+	if rawUrl == "-" {
+		reader = io.NopCloser(os.Stdin)
+	} else if reader, err = Open(rawUrl); err != nil {
+		return err
+	}
+	defer reader.Close()
+	decompressed, err := decompress(reader)
+	if err != nil {
+		return fmt.Errorf("unable to decompress url %q: %w", rawUrl, err)
+	}
+	defer decompressed.Close()
+
+	if err := evalQuery(writer, decompressed, query, options); err != nil {
+		return fmt.Errorf("unable to query url %q: %w", rawUrl, err)
+	}
+	return nil
+}
+
+//
+// Guess the format from the extension, ignoring any compression extension
+//
+func guessQueryFormat(rawUrl string) string {
+	switch dataExtension(rawUrl) {
+	case ".csv":
+		return "csv"
+	case ".json", ".jsonl", ".ndjson":
+		return "json"
+	}
+	return ""
+}
+
+//
+// Evaluate the query against the records from reader
+//
+func evalQuery(writer io.Writer, reader io.Reader, query *sqlQuery, options QueryOptions) error {
+	var next func() (*sqlRecord, error)
+	if options.InputFormat == "csv" {
+		next = csvRecords(reader, options.NoHeader)
+	} else {
+		next = jsonRecords(reader)
+	}
+
+	output := newRecordWriter(writer, options.OutputFormat)
+	count, written := 0, 0
+	for query.limit < 0 || written < query.limit {
+		record, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if record, err = query.project(record); err != nil {
+			return err
+		} else if record == nil {
+			continue
+		}
+		if query.count {
+			count++
+			continue
+		}
+		if err := output.write(record); err != nil {
+			return err
+		}
+		written++
+	}
+
+	if query.count {
+		output.write(&sqlRecord{names: []string{"_1"}, values: []interface{}{float64(count)}})
+	}
+	return output.flush()
+}
+
+func csvRecords(reader io.Reader, noHeader bool) func() (*sqlRecord, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	var header []string
+	return func() (*sqlRecord, error) {
+		row, err := csvReader.Read()
+		if err != nil {
+			return nil, err
+		}
+		if header == nil && !noHeader {
+			header = row
+			if row, err = csvReader.Read(); err != nil {
+				return nil, err
+			}
+		}
+		record := &sqlRecord{}
+		for i, field := range row {
+			name := fmt.Sprintf("_%d", i+1)
+			if i < len(header) {
+				name = header[i]
+			}
+			record.names = append(record.names, name)
+			record.values = append(record.values, field)
+		}
+		return record, nil
+	}
+}
+
+//
+// Read one JSON object after another, keeping the top-level keys in order,
+// so that SELECT * gives back the same fields in the same order
+//
+func jsonRecords(reader io.Reader) func() (*sqlRecord, error) {
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	return func() (*sqlRecord, error) {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			return nil, fmt.Errorf("expected a JSON object, got %v", token)
+		}
+		record := &sqlRecord{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			record.names = append(record.names, key.(string))
+			record.values = append(record.values, value)
+		}
+		// The closing brace
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+}
+
+type recordWriter struct {
+	format    string
+	buffered  *bufio.Writer
+	csvWriter *csv.Writer
+}
+
+func newRecordWriter(writer io.Writer, format string) *recordWriter {
+	buffered := bufio.NewWriter(writer)
+	return &recordWriter{format: format, buffered: buffered, csvWriter: csv.NewWriter(buffered)}
+}
+
+//
+// Write a record the same way as S3 Select does: CSV without a header, or
+// one JSON object per line
+//
+func (w *recordWriter) write(record *sqlRecord) error {
+	if w.format == "csv" {
+		row := make([]string, len(record.values))
+		for i, value := range record.values {
+			row[i] = sqlString(value)
+		}
+		return w.csvWriter.Write(row)
+	}
+
+	w.buffered.WriteString("{")
+	for i, name := range record.names {
+		if i > 0 {
+			w.buffered.WriteString(",")
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(record.values[i])
+		if err != nil {
+			return err
+		}
+		w.buffered.Write(key)
+		w.buffered.WriteString(":")
+		w.buffered.Write(value)
+	}
+	_, err := w.buffered.WriteString("}\n")
+	return err
+}
+
+func (w *recordWriter) flush() error {
+	w.csvWriter.Flush()
+	if err := w.csvWriter.Error(); err != nil {
+		return err
+	}
+	return w.buffered.Flush()
+}
+
+var errSelectUnsupported = errors.New("S3 Select is not supported")
+
+//
+// Run the query with S3 Select.  Returns errSelectUnsupported before writing
+// anything if the server or the object doesn't support it, e.g. MinIO
+// without select, or zstd compression, so that we can fall back to
+// evaluating the query ourselves.
+//
+func s3_select(writer io.Writer, url string, sql string, options QueryOptions) error {
+	parsedUrl, err := parseS3Url(url)
+	if err != nil {
+		return err
+	}
+	// SelectObjectContent can't select a version
+	if parsedUrl.VersionId != "" {
+		return errSelectUnsupported
+	}
+
+	input := &types.InputSerialization{CompressionType: types.CompressionTypeNone}
+	switch name := strings.ToLower(parsedUrl.Key); {
+	case strings.HasSuffix(name, ".gz"):
+		input.CompressionType = types.CompressionTypeGzip
+	case strings.HasSuffix(name, ".bz2"):
+		input.CompressionType = types.CompressionTypeBzip2
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".xz"):
+		return errSelectUnsupported
+	}
+	output := &types.OutputSerialization{}
+	if options.InputFormat == "csv" {
+		input.CSV = &types.CSVInput{FileHeaderInfo: types.FileHeaderInfoUse}
+		if options.NoHeader {
+			input.CSV.FileHeaderInfo = types.FileHeaderInfoNone
+		}
+	} else {
+		input.JSON = &types.JSONInput{Type: types.JSONTypeLines}
+	}
+	if options.OutputFormat == "csv" {
+		output.CSV = &types.CSVOutput{}
+	} else {
+		output.JSON = &types.JSONOutput{RecordDelimiter: aws.String("\n")}
+	}
+
+	client, err := s3_client(url)
+	if err != nil {
+		return err
+	}
+	params := &s3.SelectObjectContentInput{
+		Bucket:              aws.String(parsedUrl.Bucket),
+		Key:                 aws.String(parsedUrl.Key),
+		Expression:          aws.String(sql),
+		ExpressionType:      types.ExpressionTypeSql,
+		InputSerialization:  input,
+		OutputSerialization: output,
+	}
+	response, err := client.SelectObjectContent(context.TODO(), params)
+	if isSelectUnsupported(err) {
+		return errSelectUnsupported
+	} else if err != nil {
+		return fmt.Errorf("unable to SelectObjectContent for url %q: %w", url, err)
+	}
+
+	stream := response.GetStream()
+	defer stream.Close()
+	for event := range stream.Events() {
+		if records, ok := event.(*types.SelectObjectContentEventStreamMemberRecords); ok {
+			if _, err := writer.Write(records.Value.Payload); err != nil {
+				return err
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return fmt.Errorf("unable to read query results from url %q: %w", url, err)
+	}
+	return nil
+}
+
+func isSelectUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	var codeErr interface{ ErrorCode() string }
+	if errors.As(err, &codeErr) {
+		switch codeErr.ErrorCode() {
+		case "NotImplemented", "MethodNotAllowed", "XNotImplemented", "UnsupportedOperation":
+			return true
+		}
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		switch responseErr.HTTPStatusCode() {
+		case http.StatusNotImplemented, http.StatusMethodNotAllowed:
+			return true
+		}
+	}
+	return false
+}
+
@@ -0,0 +1,154 @@
+package koshka
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const peopleCsv = `name,age,city
+Alice,34,Sydney
+Bob,27,"Melbourne, VIC"
+Carol,41,Sydney
+`
+
+const peopleJson = `{"name":"Alice","age":34,"address":{"city":"Sydney"}}
+{"name":"Bob","age":27,"address":{"city":"Melbourne"},"nick":null}
+{"name":"Carol","age":41,"address":{"city":"Sydney"}}
+`
+
+func TestQuery_local(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "people.csv")
+	jsonPath := filepath.Join(dir, "people.jsonl")
+	os.WriteFile(csvPath, []byte(peopleCsv), 0o644)
+	os.WriteFile(jsonPath, []byte(peopleJson), 0o644)
+
+	testCases := []struct {
+		rawUrl   string
+		sql      string
+		options  QueryOptions
+		expected string
+	}{
+		{csvPath, "SELECT * FROM S3Object", QueryOptions{}, "Alice,34,Sydney\nBob,27,\"Melbourne, VIC\"\nCarol,41,Sydney\n"},
+		{csvPath, "select s.name from s3object s where s.age > 30", QueryOptions{}, "Alice\nCarol\n"},
+		{csvPath, "SELECT name, age FROM S3Object WHERE city = 'Sydney' AND NOT age < 40", QueryOptions{OutputFormat: "json"}, "{\"name\":\"Carol\",\"age\":\"41\"}\n"},
+		{csvPath, "SELECT COUNT(*) FROM S3Object WHERE city LIKE 'Mel%'", QueryOptions{}, "1\n"},
+		{csvPath, "SELECT _1 FROM S3Object LIMIT 2", QueryOptions{}, "Alice\nBob\n"},
+		{csvPath, "SELECT _1, _2 FROM S3Object WHERE _2 BETWEEN 30 AND 40", QueryOptions{NoHeader: true}, "Alice,34\n"},
+		{csvPath, "SELECT UPPER(name) AS shout FROM S3Object WHERE name IN ('Bob', 'Carol')", QueryOptions{OutputFormat: "json"}, "{\"shout\":\"BOB\"}\n{\"shout\":\"CAROL\"}\n"},
+		{jsonPath, "SELECT s.name, s.address.city FROM S3Object s WHERE s.age >= 34", QueryOptions{}, "{\"name\":\"Alice\",\"city\":\"Sydney\"}\n{\"name\":\"Carol\",\"city\":\"Sydney\"}\n"},
+		{jsonPath, "SELECT * FROM S3Object WHERE nick IS NULL LIMIT 1", QueryOptions{}, "{\"name\":\"Alice\",\"age\":34,\"address\":{\"city\":\"Sydney\"}}\n"},
+		{jsonPath, "SELECT name, CAST(age AS STRING) FROM S3Object s WHERE s.name <> 'Alice'", QueryOptions{OutputFormat: "csv"}, "Bob,27\nCarol,41\n"},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		if err := Query(&buffer, tc.rawUrl, tc.sql, tc.options); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.sql, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.sql, tc.expected, actual)
+		}
+	}
+
+	for _, sql := range []string{"SELECT FROM S3Object", "SELECT * FROM people", "SELECT * FROM S3Object WHERE name = 'unterminated"} {
+		if err := Query(&bytes.Buffer{}, csvPath, sql, QueryOptions{}); err == nil {
+			t.Errorf("tc: %q expected an error", sql)
+		}
+	}
+}
+
+//
+// Without canned results, the stand-in for S3 doesn't support
+// SelectObjectContent, like many S3-compatible servers, so the query should
+// get evaluated locally
+//
+func TestQuery_s3Fallback(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "")
+	if err := Put("s3://bucket/people.csv", bytes.NewBufferString(peopleCsv)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	err := Query(&buffer, "s3://bucket/people.csv", "SELECT name FROM S3Object WHERE city = 'Sydney'", QueryOptions{OutputFormat: "json"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "{\"name\":\"Alice\"}\n{\"name\":\"Carol\"}\n"
+	if actual := buffer.String(); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func Test_s3_select(t *testing.T) {
+	f := &fakeS3{
+		bucket:   "bucket",
+		pageSize: 1000,
+		selects: map[string][]string{
+			"people.csv": {"Alice,34\n", "Carol,", "41\n"},
+			"broken.csv": {"Alice,34\n"},
+		},
+		selectErrors: map[string]string{"broken.csv": "CSVParsingError"},
+	}
+	setupFakeS3(t, f, "")
+	for _, key := range []string{"people.csv", "broken.csv", "people.csv.zst"} {
+		if err := Put("s3://bucket/"+key, strings.NewReader(peopleCsv)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The records arrive split across events however S3 likes
+	var buffer bytes.Buffer
+	sql := "SELECT name, age FROM S3Object WHERE CAST(age AS INT) > 30"
+	if err := Query(&buffer, "s3://bucket/people.csv", sql, QueryOptions{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if expected, actual := "Alice,34\nCarol,41\n", buffer.String(); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if f.expression != sql {
+		t.Errorf("expected S3 to evaluate %q, got %q", sql, f.expression)
+	}
+
+	// An error partway through the stream isn't a reason to fall back
+	err := Query(&bytes.Buffer{}, "s3://bucket/broken.csv", sql, QueryOptions{})
+	if err == nil || !strings.Contains(err.Error(), "CSVParsingError") {
+		t.Errorf("expected a CSVParsingError, got %v", err)
+	}
+
+	// S3 Select can't read zstd, so we fetch the object instead
+	f.expression = ""
+	err = s3_select(&bytes.Buffer{}, "s3://bucket/people.csv.zst", sql, QueryOptions{InputFormat: "csv"})
+	if !errors.Is(err, errSelectUnsupported) || f.expression != "" {
+		t.Errorf("expected %q without asking S3, got %v", errSelectUnsupported, err)
+	}
+}
+
+type fakeCodeError string
+
+func (e fakeCodeError) Error() string     { return string(e) }
+func (e fakeCodeError) ErrorCode() string { return string(e) }
+
+func Test_isSelectUnsupported(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{fakeCodeError("NotImplemented"), true},
+		{fmt.Errorf("operation error S3: SelectObjectContent: %w", fakeCodeError("XNotImplemented")), true},
+		{fakeCodeError("MethodNotAllowed"), true},
+		{fakeCodeError("InvalidExpressionType"), false},
+		{errors.New("connection refused"), false},
+	}
+	for _, tc := range testCases {
+		if actual := isSelectUnsupported(tc.err); actual != tc.expected {
+			t.Errorf("tc: %v expected %v, got %v", tc.err, tc.expected, actual)
+		}
+	}
+}
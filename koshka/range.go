@@ -0,0 +1,290 @@
+package koshka
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//
+// A RangeBackend can read part of an object without fetching all of it,
+// e.g. using S3 or HTTP range requests.
+//
+type RangeBackend interface {
+	OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error)
+}
+
+//
+// ByteRange selects Length bytes starting at Offset.  A negative Length means
+// everything up to the end of the object.  A negative Offset selects the last
+// -Offset bytes of the object, and Length is ignored.
+//
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+//
+// ParseByteRange parses ranges written the same way as in the HTTP Range
+// header, without the "bytes=" part: "100-199" (inclusive), "100-" (from
+// offset 100 to the end) and "-500" (the last 500 bytes).
+//
+func ParseByteRange(text string) (ByteRange, error) {
+	first, last, found := strings.Cut(strings.TrimPrefix(text, "bytes="), "-")
+	if !found || (first == "" && last == "") {
+		return ByteRange{}, fmt.Errorf("malformed byte range: %q", text)
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return ByteRange{}, fmt.Errorf("malformed byte range: %q", text)
+		}
+		return ByteRange{Offset: -suffix}, nil
+	}
+
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return ByteRange{}, fmt.Errorf("malformed byte range: %q", text)
+	}
+	if last == "" {
+		return ByteRange{Offset: offset, Length: -1}, nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < offset {
+		return ByteRange{}, fmt.Errorf("malformed byte range: %q", text)
+	}
+	return ByteRange{Offset: offset, Length: end - offset + 1}, nil
+}
+
+//
+// The value of the HTTP Range header, also understood by S3
+//
+func (r ByteRange) header() string {
+	switch {
+	case r.Offset < 0:
+		return fmt.Sprintf("bytes=%d", r.Offset)
+	case r.Length < 0:
+		return fmt.Sprintf("bytes=%d-", r.Offset)
+	default:
+		return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+	}
+}
+
+//
+// OpenRange returns a stream of part of the object at rawUrl.  Backends that
+// can't fetch ranges fall back to reading the object and skipping the
+// unwanted parts.
+//
+func OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error) {
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if rangeBackend, ok := backend.(RangeBackend); ok {
+		return rangeBackend.OpenRange(rawUrl, byteRange)
+	}
+
+	reader, err := backend.Open(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return sliceReader(reader, byteRange)
+}
+
+//
+// A file that we can seek in, e.g. a local one or one on an SFTP server
+//
+type seekableFile interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+//
+// Read the selected range of the file by seeking to it, and close the file
+// if anything goes wrong
+//
+func seekRange(file seekableFile, byteRange ByteRange) (io.ReadCloser, error) {
+	offset := byteRange.Offset
+	if offset < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		offset = max(0, info.Size()+offset)
+		byteRange.Length = -1
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if byteRange.Length < 0 {
+		return file, nil
+	}
+	return readCloser{io.LimitReader(file, byteRange.Length), file}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+//
+// Cut the selected range out of a stream of the entire object
+//
+func sliceReader(reader io.ReadCloser, byteRange ByteRange) (io.ReadCloser, error) {
+	if byteRange.Offset < 0 {
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if start := int64(len(data)) + byteRange.Offset; start > 0 {
+			data = data[start:]
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, byteRange.Offset); err != nil && err != io.EOF {
+		reader.Close()
+		return nil, err
+	}
+	if byteRange.Length < 0 {
+		return reader, nil
+	}
+	return readCloser{io.LimitReader(reader, byteRange.Length), reader}, nil
+}
+
+//
+// Stop reading after the first numLines lines
+//
+type lineLimitedReader struct {
+	reader    io.Reader
+	remaining int
+}
+
+func (l *lineLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	numBytes, err := l.reader.Read(p)
+	for i := 0; i < numBytes; i++ {
+		if p[i] == '\n' {
+			l.remaining--
+			if l.remaining == 0 {
+				return i + 1, io.EOF
+			}
+		}
+	}
+	return numBytes, err
+}
+
+//
+// Write the last numLines lines of the object at rawUrl, reading the object
+// backwards in increasingly large chunks until we have enough lines, so that
+// a tail of a huge log only fetches the end of it.
+//
+func tailLines(writer io.Writer, rawUrl string, numLines int) error {
+	info, err := Stat(rawUrl)
+	if err != nil {
+		return err
+	}
+	if info.Size < 0 {
+		// No idea where the end is, so we have to read everything
+		reader, err := Open(rawUrl)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return tailStream(writer, reader, numLines)
+	}
+
+	var tail []byte
+	chunkSize := int64(64 * 1024)
+	position := info.Size
+	for position > 0 && countLines(tail) <= numLines {
+		start := position - chunkSize
+		if start < 0 {
+			start = 0
+		}
+		reader, err := OpenRange(rawUrl, ByteRange{Offset: start, Length: position - start})
+		if err != nil {
+			return err
+		}
+		chunk, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		tail = append(chunk, tail...)
+		position = start
+		chunkSize *= 2
+	}
+
+	_, err = writer.Write(lastLines(tail, numLines))
+	return err
+}
+
+//
+// Write the last numLines lines of a stream, e.g. one that we must decompress
+// before we can tell where the lines are
+//
+func tailStream(writer io.Writer, reader io.Reader, numLines int) error {
+	ring := make([][]byte, numLines)
+	count := 0
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if len(line) > 0 {
+			ring[count%numLines] = line
+			count++
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	start := 0
+	if count > numLines {
+		start = count - numLines
+	}
+	for i := start; i < count; i++ {
+		if _, err := writer.Write(ring[i%numLines]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Count the lines in data, including an unterminated last line
+//
+func countLines(data []byte) int {
+	count := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		count++
+	}
+	return count
+}
+
+func lastLines(data []byte, numLines int) []byte {
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < numLines; i++ {
+		newline := bytes.LastIndexByte(data[:end], '\n')
+		if newline < 0 {
+			return data
+		}
+		end = newline
+	}
+	return data[end+1:]
+}
@@ -0,0 +1,91 @@
+package koshka
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteRange(t *testing.T) {
+	testCases := map[string]ByteRange{
+		"0-99":        {Offset: 0, Length: 100},
+		"100-":        {Offset: 100, Length: -1},
+		"-500":        {Offset: -500},
+		"bytes=10-10": {Offset: 10, Length: 1},
+	}
+	for tc, expected := range testCases {
+		actual, err := ParseByteRange(tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if actual != expected {
+			t.Errorf("tc: %q expected %v, got %v", tc, expected, actual)
+		}
+	}
+
+	for _, tc := range []string{"", "-", "10", "20-10", "a-b"} {
+		if _, err := ParseByteRange(tc); err == nil {
+			t.Errorf("tc: %q expected an error", tc)
+		}
+	}
+}
+
+func Test_tailLines(t *testing.T) {
+	var content strings.Builder
+	for i := 1; i <= 50000; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	path := filepath.Join(t.TempDir(), "log.txt")
+	if err := os.WriteFile(path, []byte(content.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []string
+	server := setupFakeHttp(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		http.ServeContent(w, r, "log.txt", time.Time{}, strings.NewReader(content.String()))
+	}, "")
+
+	expected := "line 49998\nline 49999\nline 50000\n"
+	for _, rawUrl := range []string{path, server.URL + "/log.txt"} {
+		var actual bytes.Buffer
+		if err := tailLines(&actual, rawUrl, 3); err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", rawUrl, err)
+		}
+		if actual.String() != expected {
+			t.Errorf("tc: %q expected %q, got %q", rawUrl, expected, actual.String())
+		}
+	}
+
+	// One HEAD for the size, then a single ranged GET for the last chunk
+	if len(requests) != 2 || requests[1] == "" {
+		t.Errorf("expected a HEAD and a ranged GET, got %q", requests)
+	}
+}
+
+func Test_sliceReader(t *testing.T) {
+	for _, tc := range []struct {
+		byteRange ByteRange
+		expected  string
+	}{
+		{ByteRange{Offset: 2, Length: 3}, "cde"},
+		{ByteRange{Offset: 5, Length: -1}, "fgh"},
+		{ByteRange{Offset: -2}, "gh"},
+		{ByteRange{Offset: -20}, "abcdefgh"},
+	} {
+		reader, err := sliceReader(io.NopCloser(strings.NewReader("abcdefgh")), tc.byteRange)
+		if err != nil {
+			t.Fatalf("tc: %v unexpected err: %q", tc.byteRange, err)
+		}
+		actual, _ := io.ReadAll(reader)
+		if string(actual) != tc.expected {
+			t.Errorf("tc: %v expected %q, got %q", tc.byteRange, tc.expected, actual)
+		}
+	}
+}
@@ -2,167 +2,671 @@ package koshka
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/url"
+	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-func s3_split(rawUrl string) (bucket, key string) {
-	parsedUrl, err := url.Parse(rawUrl)
-	if err != nil {
-		log.Fatal(err)
+//
+// Load the AWS configuration for url, taking the following keys from the
+// matching sections of kot.cfg into account:
+//
+//	endpoint_url = http://localhost:4566
+//	profile = work                     # from ~/.aws/config
+//	region = eu-west-1
+//	access_key_id = AKIA...
+//	secret_access_key = ...
+//	session_token = ...                # optional, for temporary credentials
+//	role_arn = arn:aws:iam::123456789012:role/reader
+//	path_style = true                  # for S3 stand-ins that need it
+//
+// Anything not in kot.cfg comes from the default AWS credential chain.
+// Stand-ins at an endpoint_url rarely have DNS entries for each bucket, so
+// we keep the bucket in the path for those, unless path_style is false.
+//
+func s3_configure(kotConfig map[string]string) (aws.Config, error) {
+	var options []func(*config.LoadOptions) error
+
+	if endpointUrl, ok := kotConfig["endpoint_url"]; ok {
+		pathStyle, err := strconv.ParseBool(kotConfig["path_style"])
+		virtualHosted := err == nil && !pathStyle
+		// https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/endpoints/
+		customResolver := aws.EndpointResolverWithOptionsFunc(
+			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: endpointUrl, HostnameImmutable: !virtualHosted}, nil
+			},
+		)
+		options = append(options, config.WithEndpointResolverWithOptions(customResolver))
+	}
+	if profile, ok := kotConfig["profile"]; ok {
+		options = append(options, config.WithSharedConfigProfile(profile))
+	}
+	if region, ok := kotConfig["region"]; ok {
+		options = append(options, config.WithRegion(region))
+	}
+	if accessKeyId, ok := kotConfig["access_key_id"]; ok {
+		provider := credentials.NewStaticCredentialsProvider(
+			accessKeyId,
+			kotConfig["secret_access_key"],
+			kotConfig["session_token"],
+		)
+		options = append(options, config.WithCredentialsProvider(provider))
 	}
 
-	if parsedUrl.Scheme != "s3" {
-		log.Fatalf("not an S3 url: %s", rawUrl)
+	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	if err != nil {
+		return cfg, err
 	}
 
-	bucket = parsedUrl.Host
-	key = strings.TrimLeft(parsedUrl.Path, "/")
-	return
+	if roleArn, ok := kotConfig["role_arn"]; ok {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn)
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
 }
 
-func s3_configure(url string) (aws.Config, error) {
+var (
+	s3ClientsMutex sync.Mutex
+	s3Clients      = make(map[string]*s3.Client)
+)
+
+//
+// Return a client configured for url.  URLs with the same configuration
+// share a client, so that e.g. we assume a role only once.
+//
+func s3_client(url string) (*s3.Client, error) {
 	kotConfig, err := findConfig(url, "")
-	if err == nil {
-		if endpointUrl, ok := kotConfig["endpoint_url"]; ok {
-			// https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/endpoints/
-			customResolver := aws.EndpointResolverWithOptionsFunc(
-				func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-					return aws.Endpoint{URL: endpointUrl, HostnameImmutable: true}, nil
-				},
-			)
-			return config.LoadDefaultConfig(
-				context.TODO(),
-				config.WithEndpointResolverWithOptions(customResolver),
-			)
-		}
+	if err != nil {
+		kotConfig = map[string]string{}
+	}
+	delete(kotConfig, "alias")
+
+	// fmt prints maps sorted by key, so this identifies the configuration
+	cacheKey := fmt.Sprint(kotConfig)
+	s3ClientsMutex.Lock()
+	defer s3ClientsMutex.Unlock()
+	if client, ok := s3Clients[cacheKey]; ok {
+		return client, nil
+	}
+
+	cfg, err := s3_configure(kotConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load configuration for url %q: %w", url, err)
 	}
-	return config.LoadDefaultConfig(context.TODO())
+	pathStyle, _ := strconv.ParseBool(kotConfig["path_style"])
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+	})
+	s3Clients[cacheKey] = client
+	return client, nil
+}
+
+type s3Backend struct{}
+
+func init() {
+	Register("s3", s3Backend{})
 }
 
-func s3_cat(url string) error {
-	bucket, key := s3_split(url)
+func (s3Backend) Open(rawUrl string) (io.ReadCloser, error) {
+	return s3_open(rawUrl)
+}
 
-	cfg, err := s3_configure(url)
+func (s3Backend) OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error) {
+	return s3_open_range(rawUrl, byteRange.header())
+}
+
+func (s3Backend) Create(rawUrl string) (io.WriteCloser, error) {
+	return s3_create(rawUrl)
+}
+
+func (s3Backend) Remove(rawUrl string) error {
+	return s3_remove(rawUrl)
+}
+
+func (s3Backend) ListFull(prefix string) ([]string, error) {
+	return s3_list_up_to(prefix, 0)
+}
+
+func (s3Backend) Walk(prefix string) ([]string, error) {
+	return s3_walk(prefix)
+}
+
+func (s3Backend) Stat(rawUrl string) (ObjectInfo, error) {
+	return s3_stat(rawUrl)
+}
+
+func (s3Backend) List(prefix string) ([]string, error) {
+	return s3_list(prefix)
+}
+
+func s3_open(url string) (io.ReadCloser, error) {
+	return s3_open_range(url, "")
+}
+
+//
+// Read the object at url, or only the part of it specified by byteRange,
+// which is in the same format as the HTTP Range header
+//
+func s3_open_range(url string, byteRange string) (io.ReadCloser, error) {
+	parsedUrl, err := parseS3Url(url)
 	if err != nil {
-		return fmt.Errorf("unable to load configuration for url %q: %w", url, err)
+		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg)
-	params := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	client, err := s3_client(url)
+	if err != nil {
+		return nil, err
+	}
+	params := &s3.GetObjectInput{Bucket: aws.String(parsedUrl.Bucket), Key: aws.String(parsedUrl.Key)}
+	if parsedUrl.VersionId != "" {
+		params.VersionId = aws.String(parsedUrl.VersionId)
+	}
+	if byteRange != "" {
+		params.Range = aws.String(byteRange)
+	}
 	response, err := client.GetObject(context.TODO(), params)
+	if byteRange != "" && isInvalidRange(err) {
+		//
+		// S3 refuses ranges that start past the end of the object, including
+		// any range of an empty object, where HTTP servers send nothing
+		//
+		return io.NopCloser(strings.NewReader("")), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read from url %q: %w", url, err)
+	}
+
+	return response.Body, nil
+}
+
+func isInvalidRange(err error) bool {
+	if err == nil {
+		return false
+	}
+	var codeErr interface{ ErrorCode() string }
+	if errors.As(err, &codeErr) && codeErr.ErrorCode() == "InvalidRange" {
+		return true
+	}
+	var responseErr *awshttp.ResponseError
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable
+}
+
+//
+// Stream to an S3 object.  The uploader switches to a multipart upload once
+// the input is larger than a single part, so this works for inputs of any
+// size without buffering them in memory.
+//
+func s3_create(url string) (io.WriteCloser, error) {
+	parsedUrl, err := parseS3Url(url)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := parsedUrl.Bucket, parsedUrl.Key
+	if key == "" || strings.HasSuffix(key, "/") || parsedUrl.VersionId != "" {
+		return nil, fmt.Errorf("unable to write to url %q: not an object key", url)
+	}
+
+	client, err := s3_client(url)
 	if err != nil {
-		return fmt.Errorf("unable to read from url %q: %w", url, err)
+		return nil, err
 	}
 
-	defer response.Body.Close()
-	const bufsize = 1024768
-	buffer := make([]byte, bufsize)
-	for true {
-		numBytes, err := response.Body.Read(buffer)
-		if numBytes > 0 {
-			fmt.Printf("%s", buffer[:numBytes])
-		}
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return fmt.Errorf("unable to read stream from url %q: %w", url, err)
+	uploader := manager.NewUploader(client)
+	return newUploadWriter(func(reader io.Reader) error {
+		params := &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: reader}
+		if _, err := uploader.Upload(context.TODO(), params); err != nil {
+			return fmt.Errorf("unable to upload to url %q: %w", url, err)
 		}
+		return nil
+	}), nil
+}
+
+//
+// Delete an S3 object, or a specific version of it.  Deleting without a
+// version in a versioned bucket leaves a delete marker behind.
+//
+func s3_remove(url string) error {
+	parsedUrl, err := parseS3Url(url)
+	if err != nil {
+		return err
+	}
+	if parsedUrl.Key == "" || strings.HasSuffix(parsedUrl.Key, "/") {
+		return fmt.Errorf("unable to remove url %q: not an object key", url)
 	}
 
+	client, err := s3_client(url)
+	if err != nil {
+		return err
+	}
+	params := &s3.DeleteObjectInput{Bucket: aws.String(parsedUrl.Bucket), Key: aws.String(parsedUrl.Key)}
+	if parsedUrl.VersionId != "" {
+		params.VersionId = aws.String(parsedUrl.VersionId)
+	}
+	if _, err := client.DeleteObject(context.TODO(), params); err != nil {
+		return fmt.Errorf("unable to DeleteObject for url %q: %w", url, err)
+	}
 	return nil
 }
 
+func s3_stat(url string) (ObjectInfo, error) {
+	info := ObjectInfo{Url: url}
+	parsedUrl, err := parseS3Url(url)
+	if err != nil {
+		return info, err
+	}
+	if parsedUrl.Key == "" || strings.HasSuffix(parsedUrl.Key, "/") {
+		info.IsDir = true
+		return info, nil
+	}
+
+	client, err := s3_client(url)
+	if err != nil {
+		return info, err
+	}
+	params := &s3.HeadObjectInput{
+		Bucket:       aws.String(parsedUrl.Bucket),
+		Key:          aws.String(parsedUrl.Key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+	if parsedUrl.VersionId != "" {
+		params.VersionId = aws.String(parsedUrl.VersionId)
+	}
+	response, err := client.HeadObject(context.TODO(), params)
+	if err != nil {
+		return info, fmt.Errorf("unable to HeadObject for url %q: %w", url, err)
+	}
+
+	info.Size = aws.ToInt64(response.ContentLength)
+	info.ModTime = aws.ToTime(response.LastModified)
+	info.ETag = aws.ToString(response.ETag)
+	info.ContentType = aws.ToString(response.ContentType)
+	info.StorageClass = string(response.StorageClass)
+	if info.StorageClass == "" {
+		// S3 leaves out the storage class of STANDARD objects
+		info.StorageClass = string(types.StorageClassStandard)
+	}
+	info.Metadata = response.Metadata
+	info.Checksums = s3_checksums(response)
+	return info, nil
+}
+
+//
+// The ETag of an object is the MD5 of its contents, unless it was a multipart
+// upload, which has -N at the end of the ETag, or encrypted with KMS.  S3
+// only has other checksums if the uploader asked for them, and we can't
+// verify those of multipart uploads either, because they're checksums of
+// the checksums of the parts.
+//
+func s3_checksums(response *s3.HeadObjectOutput) map[string]string {
+	checksums := make(map[string]string)
+	etag := strings.Trim(aws.ToString(response.ETag), "\"")
+	isKms := strings.HasPrefix(string(response.ServerSideEncryption), "aws:kms") || response.SSECustomerAlgorithm != nil
+	if _, err := hex.DecodeString(etag); err == nil && len(etag) == 32 && !isKms {
+		checksums["md5"] = strings.ToLower(etag)
+	}
+	for algorithm, checksum := range map[string]string{
+		"crc32c": aws.ToString(response.ChecksumCRC32C),
+		"sha256": aws.ToString(response.ChecksumSHA256),
+	} {
+		if checksum != "" && !strings.Contains(checksum, "-") {
+			checksums[algorithm] = base64ToHex(checksum)
+		}
+	}
+	return checksums
+}
+
 func s3_list(prefix string) (candidates []string, err error) {
+	return s3_list_up_to(prefix, s3_max_candidates(prefix))
+}
+
+//
+// List at most maxCandidates entries, narrowing them down into groups when
+// there are more.  Zero or less means list everything.
+//
+func s3_list_up_to(prefix string, maxCandidates int) (candidates []string, err error) {
 	if prefix == "" {
 		return candidates, errors.New("unable to list empty prefix")
 	}
 
-	bucket, keyPrefix := s3_split(prefix)
-	cfg, err := s3_configure(prefix)
-	if err != nil {
-		return candidates, fmt.Errorf("unable to load configuration for url %q: %w", prefix, err)
+	if marker := strings.LastIndex(prefix, "?"); marker >= 0 && isVersionQuery(prefix[marker:]) {
+		candidates, err = s3_list_versions(prefix[:marker], prefix[marker:])
+		if err != nil || len(candidates) > 0 {
+			return candidates, err
+		}
+		// Not a versioned object, so perhaps the key contains a question mark
 	}
 
-	client := s3.NewFromConfig(cfg)
+	parsedUrl, err := parseS3Url(prefix)
+	if err != nil {
+		return candidates, err
+	}
+	bucket, keyPrefix := parsedUrl.Bucket, parsedUrl.Key
+	client, err := s3_client(prefix)
+	if err != nil {
+		return candidates, err
+	}
 
 	//
-	// Attempt bucket name autocompletion
+	// Attempt bucket name autocompletion, unless the bucket is complete,
+	// i.e. followed by a slash.
 	//
-	if keyPrefix == "" {
+	if bucket == "" || (keyPrefix == "" && !strings.HasSuffix(prefix, bucket+"/")) {
 		listBucketsParams := &s3.ListBucketsInput{}
 		response, err := client.ListBuckets(context.TODO(), listBucketsParams)
 		if err != nil {
 			return candidates, fmt.Errorf("unable to ListBuckets: %w", err)
 		}
-		matchingBuckets := []string{}
-		for _, b := range(response.Buckets) {
+		for _, b := range response.Buckets {
 			if strings.HasPrefix(*b.Name, bucket) {
-				matchingBuckets = append(matchingBuckets, *b.Name)
+				candidates = append(candidates, s3Url{Bucket: *b.Name}.String())
 			}
 		}
-
-		if len(matchingBuckets) == 1 {
-			bucket = matchingBuckets[0]
-			keyPrefix = ""
-		} else {
-			for _, b := range matchingBuckets {
-				candidates = append(candidates, fmt.Sprintf("//%s", b))
-			}
-			return candidates, nil
-		}
+		return candidates, nil
 	}
 
-	//
-	// Drill down as far as possible
-	//
-	for true {
-		// log.Printf("prefix: %s", prefix)
-		listObjectsParams := &s3.ListObjectsInput{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(keyPrefix),
-			Delimiter: aws.String("/"),
-		}
-
-		response, err := client.ListObjects(context.TODO(), listObjectsParams)
+	var prefixes, objects []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(keyPrefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(context.TODO())
 		if err != nil {
 			return candidates, fmt.Errorf(
-				"unable to ListObjects for bucket %q prefix %q: %w",
+				"unable to ListObjectsV2 for bucket %q prefix %q: %w",
 				bucket,
 				prefix,
 				err,
 			)
 		}
 
-		if len(response.CommonPrefixes) == 1 && len(response.Contents) == 0 {
-			keyPrefix = *response.CommonPrefixes[0].Prefix
-			continue
+		for _, cp := range response.CommonPrefixes {
+			prefixes = append(prefixes, s3Url{Bucket: bucket, Key: *cp.Prefix}.String())
+		}
+		for _, obj := range response.Contents {
+			objects = append(objects, s3Url{Bucket: bucket, Key: *obj.Key}.String())
 		}
 
-		// TODO: pagination?  Is it really worth it?
-		// FIXME: why _must_ we include the //bucket, but exclude the s3: part?
-		// Is colon some sort of special character for the autocompletion engine?
+		if maxCandidates > 0 && len(prefixes)+len(objects) > maxCandidates {
+			return s3_narrow(client, bucket, keyPrefix, maxCandidates)
+		}
+	}
 
-		for _, cp := range response.CommonPrefixes {
-			fullUrl := fmt.Sprintf("//%s/%s", bucket, *cp.Prefix)
-			candidates = append(candidates, fullUrl)
+	return append(prefixes, objects...), nil
+}
+
+//
+// Reading a huge listing page by page is slow, and nobody wants to look at
+// thousands of candidates anyway.  Instead, offer the prefix extended by
+// each distinct character that follows it, e.g. logs/a, logs/b, logs/c...
+// The user can then narrow down further by typing.
+//
+// Finding the distinct characters does not require listing everything: once
+// we've seen a key starting with logs/a, we can skip straight past all the
+// other keys in that group using StartAfter.  This takes one request per
+// group.
+//
+func s3_narrow(client *s3.Client, bucket, keyPrefix string, maxCandidates int) (candidates []string, err error) {
+	startAfter := keyPrefix
+	for len(candidates) < maxCandidates {
+		response, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+			Bucket:     aws.String(bucket),
+			Prefix:     aws.String(keyPrefix),
+			StartAfter: aws.String(startAfter),
+			MaxKeys:    aws.Int32(1),
+		})
+		if err != nil {
+			return candidates, fmt.Errorf(
+				"unable to ListObjectsV2 for bucket %q prefix %q: %w",
+				bucket,
+				keyPrefix,
+				err,
+			)
+		}
+		if len(response.Contents) == 0 {
+			break
 		}
 
+		key := *response.Contents[0].Key
+		next, _ := utf8.DecodeRuneInString(key[len(keyPrefix):])
+		group := keyPrefix + string(next)
+		candidates = append(candidates, s3Url{Bucket: bucket, Key: group}.String())
+
+		// Every other key in the group sorts before this
+		startAfter = group + string(utf8.MaxRune)
+	}
+	return candidates, nil
+}
+
+//
+// List every object under the prefix, without a delimiter, so that we don't
+// need a request for each level of the hierarchy
+//
+func s3_walk(prefix string) (objects []string, err error) {
+	parsedUrl, err := parseS3Url(prefix)
+	if err != nil {
+		return objects, err
+	}
+	if parsedUrl.Bucket == "" {
+		return objects, fmt.Errorf("unable to walk url %q: no bucket", prefix)
+	}
+	client, err := s3_client(prefix)
+	if err != nil {
+		return objects, err
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(parsedUrl.Bucket),
+		Prefix: aws.String(parsedUrl.Key),
+	})
+	for paginator.HasMorePages() {
+		response, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return objects, fmt.Errorf(
+				"unable to ListObjectsV2 for bucket %q prefix %q: %w",
+				parsedUrl.Bucket,
+				parsedUrl.Key,
+				err,
+			)
+		}
 		for _, obj := range response.Contents {
-			fullUrl := fmt.Sprintf("//%s/%s", bucket, *obj.Key)
-			candidates = append(candidates, fullUrl)
+			// Skip the placeholders that consoles create for empty "directories"
+			if !strings.HasSuffix(*obj.Key, "/") {
+				objects = append(objects, s3Url{Bucket: parsedUrl.Bucket, Key: *obj.Key}.String())
+			}
 		}
+	}
+	return objects, nil
+}
 
-		break
+//
+// The maximum number of completion candidates to collect before narrowing,
+// configurable via the max_candidates key in kot.cfg
+//
+func s3_max_candidates(url string) int {
+	const defaultMaxCandidates = 1000
+	kotConfig, err := findConfig(url, "")
+	if err != nil {
+		return defaultMaxCandidates
 	}
+	if value, ok := kotConfig["max_candidates"]; ok {
+		if maxCandidates, err := strconv.Atoi(value); err == nil && maxCandidates > 0 {
+			return maxCandidates
+		}
+	}
+	return defaultMaxCandidates
+}
+
+const versionMarker = "?versionId="
+
+//
+// Is the query a partially or fully typed version marker, e.g. "?ver" or
+// "?versionId=3HL4"?
+//
+func isVersionQuery(query string) bool {
+	return strings.HasPrefix(versionMarker, query) || strings.HasPrefix(query, versionMarker)
+}
 
+//
+// Complete the version ID part of an S3 URL.  The query is what the user has
+// typed after the key so far.  Unlike kot -versions, the candidates carry no
+// timestamps, because the shell inserts whichever one is picked verbatim, so
+// the best we can do is to offer them newest first.
+//
+func s3_list_versions(rawUrl string, query string) (candidates []string, err error) {
+	versionPrefix := ""
+	if strings.HasPrefix(query, versionMarker) {
+		versionPrefix = query[len(versionMarker):]
+	}
+
+	versions, err := ListVersions(rawUrl)
+	if err != nil {
+		return candidates, err
+	}
+	for _, v := range versions {
+		if strings.HasPrefix(v.VersionId, versionPrefix) {
+			candidates = append(candidates, v.Url)
+		}
+	}
 	return candidates, nil
 }
+
+type ObjectVersion struct {
+	Url       string
+	VersionId string
+	ModTime   time.Time
+	Size      int64
+	IsLatest  bool
+}
+
+//
+// ListVersions returns all versions of the S3 object at rawUrl, newest first.
+// The Url of each version can be passed to Cat to read that version.
+//
+func ListVersions(rawUrl string) (versions []ObjectVersion, err error) {
+	if schemeOf(rawUrl) != "s3" {
+		return versions, fmt.Errorf("versions are only supported for S3: %s", rawUrl)
+	}
+
+	parsedUrl, err := parseS3Url(rawUrl)
+	if err != nil {
+		return versions, err
+	}
+	bucket, key := parsedUrl.Bucket, parsedUrl.Key
+	client, err := s3_client(rawUrl)
+	if err != nil {
+		return versions, err
+	}
+	params := &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(key)}
+	for {
+		response, err := client.ListObjectVersions(context.TODO(), params)
+		if err != nil {
+			return versions, fmt.Errorf(
+				"unable to ListObjectVersions for bucket %q key %q: %w",
+				bucket,
+				key,
+				err,
+			)
+		}
+
+		for _, v := range response.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			versionId := aws.ToString(v.VersionId)
+			versions = append(versions, ObjectVersion{
+				Url:       s3Url{Bucket: bucket, Key: key, VersionId: versionId}.String(),
+				VersionId: versionId,
+				ModTime:   aws.ToTime(v.LastModified),
+				Size:      aws.ToInt64(v.Size),
+				IsLatest:  aws.ToBool(v.IsLatest),
+			})
+		}
+
+		if !aws.ToBool(response.IsTruncated) {
+			break
+		}
+		params.KeyMarker = response.NextKeyMarker
+		params.VersionIdMarker = response.NextVersionIdMarker
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ModTime.After(versions[j].ModTime)
+	})
+	return versions, nil
+}
+
+//
+// S3 doesn't accept presigned URLs that are valid for longer than a week
+//
+const maxPresignExpires = 7 * 24 * time.Hour
+
+//
+// Presign returns a URL that lets anybody GET, or PUT, the S3 object at
+// rawUrl without credentials of their own, until expires has passed.  The
+// URL is signed with the credentials of the matching section of kot.cfg, and
+// points at its endpoint_url, if any.  Temporary credentials, e.g. from an
+// assumed role, may stop the URL from working before it expires.
+//
+func Presign(rawUrl string, method string, expires time.Duration) (string, error) {
+	if schemeOf(rawUrl) != "s3" {
+		return "", fmt.Errorf("presigning is only supported for S3: %s", rawUrl)
+	}
+	if expires <= 0 || expires > maxPresignExpires {
+		return "", fmt.Errorf("unable to presign url %q: expiry must be between 1s and %s", rawUrl, maxPresignExpires)
+	}
+
+	parsedUrl, err := parseS3Url(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if parsedUrl.Key == "" || strings.HasSuffix(parsedUrl.Key, "/") {
+		return "", fmt.Errorf("unable to presign url %q: not an object key", rawUrl)
+	}
+	client, err := s3_client(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	presignClient := s3.NewPresignClient(client, s3.WithPresignExpires(expires))
+	var request *v4.PresignedHTTPRequest
+	switch method {
+	case http.MethodGet:
+		params := &s3.GetObjectInput{Bucket: aws.String(parsedUrl.Bucket), Key: aws.String(parsedUrl.Key)}
+		if parsedUrl.VersionId != "" {
+			params.VersionId = aws.String(parsedUrl.VersionId)
+		}
+		request, err = presignClient.PresignGetObject(context.TODO(), params)
+	case http.MethodPut:
+		if parsedUrl.VersionId != "" {
+			return "", fmt.Errorf("unable to presign url %q: cannot PUT a version", rawUrl)
+		}
+		params := &s3.PutObjectInput{Bucket: aws.String(parsedUrl.Bucket), Key: aws.String(parsedUrl.Key)}
+		request, err = presignClient.PresignPutObject(context.TODO(), params)
+	default:
+		return "", fmt.Errorf("unable to presign url %q: unsupported method %q", rawUrl, method)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to presign %s for url %q: %w", method, rawUrl, err)
+	}
+	return request.URL, nil
+}
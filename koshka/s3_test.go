@@ -0,0 +1,671 @@
+package koshka
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+)
+
+//
+// A minimal stand-in for S3 that serves ListObjectsV2, GetObject and
+// PutObject for a single bucket.  It returns at most pageSize entries per
+// listing, so that pagination is exercised even for small listings.
+//
+type fakeS3 struct {
+	bucket   string
+	keys     []string
+	objects  map[string][]byte
+	pageSize int
+	requests int
+	// Extra headers to send along with objects, e.g. metadata
+	headers map[string]http.Header
+	// Earlier versions of objects, oldest first, for ListObjectVersions
+	versions map[string][]fakeVersion
+	// What SelectObjectContent streams back for each key, one Records event
+	// per item, followed by an error if there is one for the key.  Keys
+	// without any don't support S3 Select.
+	selects      map[string][]string
+	selectErrors map[string]string
+	// The SQL of the last SelectObjectContent request
+	expression string
+	// The Authorization header of the last request
+	authorization string
+	// The role that the last STS AssumeRole request asked for
+	assumedRole string
+	mutex         sync.Mutex
+}
+
+type fakeListing struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string             `xml:",omitempty"`
+	Contents              []fakeObject       `xml:"Contents"`
+	CommonPrefixes        []fakeCommonPrefix `xml:"CommonPrefixes"`
+}
+
+type fakeObject struct {
+	Key  string
+	Size int
+}
+
+type fakeCommonPrefix struct {
+	Prefix string
+}
+
+type fakeVersion struct {
+	VersionId string
+	Data      []byte
+	ModTime   time.Time
+}
+
+type fakeVersionListing struct {
+	XMLName     xml.Name `xml:"ListVersionsResult"`
+	Name        string
+	Prefix      string
+	IsTruncated bool
+	Versions    []fakeVersionEntry `xml:"Version"`
+}
+
+type fakeVersionEntry struct {
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	LastModified string
+	Size         int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests++
+	f.authorization = r.Header.Get("Authorization")
+	query := r.URL.Query()
+	if key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/"); ok && key != "" {
+		f.serveObject(w, r, key)
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/" && r.FormValue("Action") == "AssumeRole" {
+		f.serveAssumeRole(w, r.FormValue("RoleArn"))
+		return
+	}
+	if r.URL.Path == "/"+f.bucket && query.Has("versions") {
+		f.serveVersions(w, query.Get("prefix"))
+		return
+	}
+	if r.URL.Path != "/"+f.bucket || query.Get("list-type") != "2" {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := f.pageSize
+	if m, err := strconv.Atoi(query.Get("max-keys")); err == nil && m < maxKeys {
+		maxKeys = m
+	}
+
+	listing := fakeListing{Name: f.bucket, Prefix: prefix}
+	for _, key := range f.keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		// Skip the rest of a common prefix that has already been returned
+		if delimiter != "" && strings.HasSuffix(after, delimiter) && strings.HasPrefix(key, after) {
+			continue
+		}
+		if listing.KeyCount == maxKeys {
+			listing.IsTruncated = true
+			break
+		}
+
+		rest := key[len(prefix):]
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			cp := prefix + rest[:i+1]
+			listing.CommonPrefixes = append(listing.CommonPrefixes, fakeCommonPrefix{cp})
+			listing.NextContinuationToken = cp
+			after = cp
+		} else {
+			listing.Contents = append(listing.Contents, fakeObject{Key: key})
+			listing.NextContinuationToken = key
+		}
+		listing.KeyCount++
+	}
+	if !listing.IsTruncated {
+		listing.NextContinuationToken = ""
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(listing)
+}
+
+func (f *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodPost:
+		if _, ok := f.selects[key]; !ok || !r.URL.Query().Has("select") {
+			http.Error(w, "not implemented", http.StatusNotImplemented)
+			return
+		}
+		f.serveSelect(w, r, key)
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.objects == nil {
+			f.objects = make(map[string][]byte)
+		}
+		if _, ok := f.objects[key]; !ok {
+			f.keys = append(f.keys, key)
+			sort.Strings(f.keys)
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(data)))
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if versionId := r.URL.Query().Get("versionId"); versionId != "" {
+			data, ok = nil, false
+			for _, v := range f.versions[key] {
+				if v.VersionId == versionId {
+					data, ok = v.Data, true
+				}
+			}
+		}
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", md5.Sum(data)))
+		for name, values := range f.headers[key] {
+			w.Header()[name] = values
+		}
+		if len(data) == 0 && r.Header.Get("Range") != "" {
+			//
+			// Unlike http.ServeContent, S3 refuses any range of an empty object
+			//
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			fmt.Fprint(w, "<Error><Code>InvalidRange</Code><Message>The requested range is not satisfiable</Message></Error>")
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) serveVersions(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range f.versions {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	listing := fakeVersionListing{Name: f.bucket, Prefix: prefix}
+	for _, key := range keys {
+		versions := f.versions[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			listing.Versions = append(listing.Versions, fakeVersionEntry{
+				Key:          key,
+				VersionId:    versions[i].VersionId,
+				IsLatest:     i == len(versions)-1,
+				LastModified: versions[i].ModTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+				Size:         len(versions[i].Data),
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(listing)
+}
+
+type fakeSelectRequest struct {
+	Expression string
+}
+
+//
+// Stream the canned records back in the event stream format of
+// SelectObjectContent
+//
+func (f *fakeS3) serveSelect(w http.ResponseWriter, r *http.Request, key string) {
+	var request fakeSelectRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.expression = request.Expression
+
+	encoder := eventstream.NewEncoder()
+	event := func(eventType string, payload string) {
+		message := eventstream.Message{Payload: []byte(payload)}
+		message.Headers.Set(":message-type", eventstream.StringValue("event"))
+		message.Headers.Set(":event-type", eventstream.StringValue(eventType))
+		encoder.Encode(w, message)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	for _, records := range f.selects[key] {
+		event("Records", records)
+	}
+	if code, ok := f.selectErrors[key]; ok {
+		message := eventstream.Message{}
+		message.Headers.Set(":message-type", eventstream.StringValue("error"))
+		message.Headers.Set(":error-code", eventstream.StringValue(code))
+		message.Headers.Set(":error-message", eventstream.StringValue("select failed"))
+		encoder.Encode(w, message)
+		return
+	}
+	event("End", "")
+}
+
+//
+// The endpoint_url applies to STS too, so we stand in for that as well
+//
+func (f *fakeS3) serveAssumeRole(w http.ResponseWriter, roleArn string) {
+	f.assumedRole = roleArn
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<AssumeRoleResult>
+<Credentials>
+<AccessKeyId>AKIDROLE</AccessKeyId>
+<SecretAccessKey>sekrit</SecretAccessKey>
+<SessionToken>session</SessionToken>
+<Expiration>%s</Expiration>
+</Credentials>
+<AssumedRoleUser><Arn>%s/kot</Arn><AssumedRoleId>AROA:kot</AssumedRoleId></AssumedRoleUser>
+</AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), roleArn)
+}
+
+//
+// Point the S3 backend at the stand-in via kot.cfg, the same way a user would
+// point it at localstack.
+//
+func setupFakeS3(t *testing.T, f *fakeS3, extraConfig string) {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, "aws_config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, "aws_credentials"))
+
+	cfg := fmt.Sprintf("[s3://%s]\nendpoint_url = %s\n%s", f.bucket, server.URL, extraConfig)
+	if err := os.WriteFile(filepath.Join(home, "kot.cfg"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_s3_list_pagination(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 2}
+	for i := 0; i < 7; i++ {
+		f.keys = append(f.keys, fmt.Sprintf("logs/part-%d", i))
+	}
+	f.keys = append(f.keys, "logs/archive/1", "logs/archive/2")
+	sort.Strings(f.keys)
+	setupFakeS3(t, f, "")
+
+	actual, err := s3_list("s3://bucket/logs/")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := []string{"s3://bucket/logs/archive/"}
+	for i := 0; i < 7; i++ {
+		expected = append(expected, fmt.Sprintf("s3://bucket/logs/part-%d", i))
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if f.requests < 4 {
+		t.Errorf("expected at least 4 paginated requests, got %d", f.requests)
+	}
+}
+
+func Test_s3_list_narrow(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 2}
+	for _, c := range "abc" {
+		for i := 0; i < 100; i++ {
+			f.keys = append(f.keys, fmt.Sprintf("data/%c%03d", c, i))
+		}
+	}
+	sort.Strings(f.keys)
+	setupFakeS3(t, f, "max_candidates = 5\n")
+
+	actual, err := s3_list("s3://bucket/data/")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := []string{"s3://bucket/data/a", "s3://bucket/data/b", "s3://bucket/data/c"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if f.requests > 10 {
+		t.Errorf("expected narrowing to take few requests, got %d", f.requests)
+	}
+}
+
+func TestListAll_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 100}
+	for i := 0; i < 300; i++ {
+		f.keys = append(f.keys, fmt.Sprintf("data/%03d", i))
+	}
+	setupFakeS3(t, f, "max_candidates = 5\n")
+
+	actual, err := ListAll("s3://bucket/data/")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if len(actual) != 300 || actual[0] != "s3://bucket/data/000" || actual[299] != "s3://bucket/data/299" {
+		t.Errorf("expected all 300 keys regardless of max_candidates, got %d: %q", len(actual), actual)
+	}
+}
+
+func TestPut_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "")
+
+	if err := Put("s3://bucket/dir/hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if actual := string(f.objects["dir/hello.txt"]); actual != "hello" {
+		t.Errorf("expected %q, got %q", "hello", actual)
+	}
+
+	reader, err := Open("s3://bucket/dir/hello.txt")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	defer reader.Close()
+	if actual, _ := io.ReadAll(reader); string(actual) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", actual)
+	}
+}
+
+func TestCatWithOptions_s3_empty(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000, objects: map[string][]byte{"empty.txt": {}}}
+	setupFakeS3(t, f, "")
+
+	testCases := map[string]CatOptions{
+		"plain":      {},
+		"head lines": {HeadLines: 10},
+		"tail lines": {TailLines: 10},
+		"head bytes": {Range: &ByteRange{Offset: 0, Length: 10}},
+		"tail bytes": {Range: &ByteRange{Offset: -10}},
+		"range":      {Range: &ByteRange{Offset: 5, Length: -1}},
+	}
+	for tc, options := range testCases {
+		var buffer bytes.Buffer
+		if err := CatWithOptions(&buffer, "s3://bucket/empty.txt", options); err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if actual := buffer.String(); actual != "" {
+			t.Errorf("tc: %q expected %q, got %q", tc, "", actual)
+		}
+	}
+}
+
+func TestPutIfUnchanged_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "")
+
+	rawUrl := "s3://bucket/app.cfg"
+	if err := Put(rawUrl, strings.NewReader("debug = false")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	info, err := Stat(rawUrl)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if info.ETag == "" {
+		t.Errorf("expected an ETag for %q", rawUrl)
+	}
+
+	if err := PutIfUnchanged(rawUrl, strings.NewReader("debug = true"), info); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	err = PutIfUnchanged(rawUrl, strings.NewReader("debug = maybe"), info)
+	if !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("expected %q, got %q", ErrObjectChanged, err)
+	}
+	if actual := string(f.objects["app.cfg"]); actual != "debug = true" {
+		t.Errorf("expected %q, got %q", "debug = true", actual)
+	}
+}
+
+func Test_s3_client_credentials(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "access_key_id = AKIDKOT\nsecret_access_key = sekrit\nregion = eu-west-3\n")
+
+	if _, err := s3_list("s3://bucket/"); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if !strings.Contains(f.authorization, "Credential=AKIDKOT/") || !strings.Contains(f.authorization, "/eu-west-3/s3/") {
+		t.Errorf("expected credentials and region from kot.cfg, got %q", f.authorization)
+	}
+}
+
+func Test_s3_client_profile(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "profile = work\n")
+	// The environment would take precedence over the region of the profile
+	t.Setenv("AWS_REGION", "")
+	home := os.Getenv("HOME")
+	os.WriteFile(filepath.Join(home, "aws_config"), []byte("[profile work]\nregion = ap-southeast-2\n"), 0o600)
+	os.WriteFile(
+		filepath.Join(home, "aws_credentials"),
+		[]byte("[work]\naws_access_key_id = AKIDWORK\naws_secret_access_key = sekrit\n"),
+		0o600,
+	)
+
+	if _, err := s3_list("s3://bucket/"); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if !strings.Contains(f.authorization, "Credential=AKIDWORK/") || !strings.Contains(f.authorization, "/ap-southeast-2/s3/") {
+		t.Errorf("expected credentials and region from the work profile, got %q", f.authorization)
+	}
+}
+
+func Test_s3_client_role(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	roleArn := "arn:aws:iam::123456789012:role/reader"
+	setupFakeS3(t, f, "access_key_id = AKIDKOT\nsecret_access_key = sekrit\nrole_arn = "+roleArn+"\n")
+
+	if _, err := s3_list("s3://bucket/"); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if f.assumedRole != roleArn {
+		t.Errorf("expected to assume %q, got %q", roleArn, f.assumedRole)
+	}
+	if !strings.Contains(f.authorization, "Credential=AKIDROLE/") {
+		t.Errorf("expected the credentials of the assumed role, got %q", f.authorization)
+	}
+}
+
+func Test_s3_client_path_style(t *testing.T) {
+	testCases := []struct {
+		config   string
+		expected string
+	}{
+		{"", "/bucket/report.csv?"},
+		{"path_style = true\n", "/bucket/report.csv?"},
+		{"path_style = false\n", "://bucket.127.0.0.1:"},
+	}
+	for _, tc := range testCases {
+		f := &fakeS3{bucket: "bucket", pageSize: 1000, keys: []string{"report.csv"}}
+		setupFakeS3(t, f, tc.config)
+
+		// Presigning shows us where requests would go, even if they can't get there
+		actual, err := Presign("s3://bucket/report.csv", http.MethodGet, time.Hour)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc.config, err)
+		}
+		if !strings.Contains(actual, tc.expected) {
+			t.Errorf("tc: %q expected %q in %q", tc.config, tc.expected, actual)
+		}
+	}
+}
+
+func TestPresign_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "access_key_id = AKIDKOT\nsecret_access_key = sekrit\n")
+	if err := Put("s3://bucket/report.csv", strings.NewReader("a,b\n")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+
+	getUrl, err := Presign("s3://bucket/report.csv", http.MethodGet, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	for _, expected := range []string{"/bucket/report.csv?", "X-Amz-Expires=3600", "X-Amz-Credential=AKIDKOT%2F", "X-Amz-Signature="} {
+		if !strings.Contains(getUrl, expected) {
+			t.Errorf("expected %q in %q", expected, getUrl)
+		}
+	}
+	response, err := http.Get(getUrl)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	actual, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(actual) != "a,b\n" {
+		t.Errorf("expected %q, got %q", "a,b\n", actual)
+	}
+
+	putUrl, err := Presign("s3://bucket/upload.txt", http.MethodPut, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	request, _ := http.NewRequest(http.MethodPut, putUrl, strings.NewReader("uploaded"))
+	if response, err = http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	response.Body.Close()
+	if actual := string(f.objects["upload.txt"]); actual != "uploaded" {
+		t.Errorf("expected %q, got %q", "uploaded", actual)
+	}
+
+	for _, tc := range []struct {
+		rawUrl  string
+		method  string
+		expires time.Duration
+	}{
+		{"s3://bucket/report.csv", http.MethodGet, 8 * 24 * time.Hour},
+		{"s3://bucket/report.csv", http.MethodDelete, time.Hour},
+		{"s3://bucket/dir/", http.MethodGet, time.Hour},
+		{"https://example.com/report.csv", http.MethodGet, time.Hour},
+	} {
+		if _, err := Presign(tc.rawUrl, tc.method, tc.expires); err == nil {
+			t.Errorf("tc: %q %s %s expected an error", tc.rawUrl, tc.method, tc.expires)
+		}
+	}
+}
+
+func TestStat_s3(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "")
+	if err := Put("s3://bucket/report.csv", strings.NewReader("a,b\n")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	f.headers = map[string]http.Header{"report.csv": {
+		"Content-Type":          {"text/csv"},
+		"X-Amz-Storage-Class":   {"STANDARD_IA"},
+		"X-Amz-Meta-Owner":      {"alice"},
+		"X-Amz-Checksum-Crc32c": {"AAAAAA==-2"},
+	}}
+
+	info, err := Stat("s3://bucket/report.csv")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := ObjectInfo{
+		Url:          "s3://bucket/report.csv",
+		Size:         4,
+		ETag:         fmt.Sprintf("\"%x\"", md5.Sum([]byte("a,b\n"))),
+		ContentType:  "text/csv",
+		StorageClass: "STANDARD_IA",
+		Metadata:     map[string]string{"owner": "alice"},
+		// The CRC32C of a multipart upload doesn't count
+		Checksums: map[string]string{"md5": fmt.Sprintf("%x", md5.Sum([]byte("a,b\n")))},
+	}
+	info.ModTime = time.Time{}
+	if !reflect.DeepEqual(expected, info) {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+}
+
+func TestListVersions_s3(t *testing.T) {
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := &fakeS3{
+		bucket:   "bucket",
+		pageSize: 1000,
+		keys:     []string{"notes.txt"},
+		objects:  map[string][]byte{"notes.txt": []byte("second")},
+		versions: map[string][]fakeVersion{
+			"notes.txt": {
+				{"v1", []byte("first"), first},
+				{"v2", []byte("second"), first.Add(time.Hour)},
+			},
+			// Shares a prefix with notes.txt, so it shows up in the listing too
+			"notes.txt.bak": {{"v3", []byte("backup"), first.Add(2 * time.Hour)}},
+		},
+	}
+	setupFakeS3(t, f, "")
+
+	actual, err := ListVersions("s3://bucket/notes.txt")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	expected := []ObjectVersion{
+		{"s3://bucket/notes.txt?versionId=v2", "v2", first.Add(time.Hour), 6, true},
+		{"s3://bucket/notes.txt?versionId=v1", "v1", first, 5, false},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	candidates, err := s3_list_versions("s3://bucket/notes.txt", "?versionId=v1")
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if expected := []string{"s3://bucket/notes.txt?versionId=v1"}; !reflect.DeepEqual(expected, candidates) {
+		t.Errorf("expected %q, got %q", expected, candidates)
+	}
+
+	// Read the version that isn't the latest one
+	var buffer bytes.Buffer
+	if err := CatWithOptions(&buffer, "s3://bucket/notes.txt?versionId=v1", CatOptions{}); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if actual := buffer.String(); actual != "first" {
+		t.Errorf("expected %q, got %q", "first", actual)
+	}
+	if err := CatWithOptions(&bytes.Buffer{}, "s3://bucket/notes.txt?versionId=v9", CatOptions{}); err == nil {
+		t.Errorf("expected an error for a missing version")
+	}
+}
@@ -0,0 +1,118 @@
+package koshka
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+//
+// An s3Url is an s3://bucket/key?versionId=... URL split into its parts
+//
+type s3Url struct {
+	Bucket    string
+	Key       string
+	VersionId string
+	// Query holds the recognised query parameters, including versionId
+	Query url.Values
+}
+
+//
+// The query parameters that we understand.  S3 keys may contain question
+// marks, so a question mark only starts a query if everything after it is
+// made of these.
+//
+var s3QueryParams = map[string]bool{"versionId": true}
+
+//
+// Split an S3 URL into its parts.  Unlike url.Parse, this takes keys more or
+// less literally, because they may contain spaces, question marks and percent
+// signs.  Percent-encoded characters in the key get decoded, as long as all
+// of them are valid escapes, so s3://bucket/a%3Fb and s3://bucket/100% both
+// do what you'd expect.  To refer to a key that literally contains something
+// like %3F, escape the percent sign: %253F.
+//
+func parseS3Url(rawUrl string) (s3Url, error) {
+	var result s3Url
+	if schemeOf(rawUrl) != "s3" {
+		return result, fmt.Errorf("not an S3 url: %q", rawUrl)
+	}
+
+	// Be lenient with partially typed URLs like s3: and s3:/
+	rest := rawUrl[len("s3:"):]
+	if !strings.HasPrefix(rest, "//") && rest != "" && rest != "/" {
+		return result, fmt.Errorf("malformed S3 url: %q", rawUrl)
+	}
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, "/"), "/")
+
+	var key string
+	result.Bucket, key, _ = strings.Cut(rest, "/")
+	if !isValidBucket(result.Bucket) {
+		return result, fmt.Errorf("invalid bucket name %q in url %q", result.Bucket, rawUrl)
+	}
+
+	if q := strings.LastIndex(key, "?"); q >= 0 {
+		if query, err := url.ParseQuery(key[q+1:]); err == nil && isS3Query(query) {
+			result.Query = query
+			result.VersionId = query.Get("versionId")
+			key = key[:q]
+		}
+	}
+
+	if unescaped, err := url.PathUnescape(key); err == nil {
+		key = unescaped
+	}
+	result.Key = key
+	return result, nil
+}
+
+func isS3Query(query url.Values) bool {
+	if len(query) == 0 {
+		return false
+	}
+	for param := range query {
+		if !s3QueryParams[param] {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Bucket names consist of lowercase letters, digits, dots and hyphens, but
+// older buckets can have uppercase letters and underscores too.  We don't
+// check the length, because partially typed names are fine for completion.
+//
+func isValidBucket(bucket string) bool {
+	for _, c := range bucket {
+		isAlnum := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+		if !isAlnum && c != '.' && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Format the URL so that parseS3Url gives back the same parts
+//
+func (u s3Url) String() string {
+	key := u.Key
+	_, err := url.PathUnescape(key)
+	looksEscaped := err == nil && strings.Contains(key, "%")
+	looksLikeQuery := false
+	if q := strings.LastIndex(key, "?"); q >= 0 {
+		query, err := url.ParseQuery(key[q+1:])
+		looksLikeQuery = err == nil && isS3Query(query)
+	}
+	if looksEscaped || looksLikeQuery {
+		key = strings.ReplaceAll(key, "%", "%25")
+		key = strings.ReplaceAll(key, "?", "%3F")
+	}
+
+	result := fmt.Sprintf("s3://%s/%s", u.Bucket, key)
+	if u.VersionId != "" {
+		result += "?" + url.Values{"versionId": {u.VersionId}}.Encode()
+	}
+	return result
+}
@@ -0,0 +1,520 @@
+package koshka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//
+// Parquet keeps its metadata in a footer at the end of the file:
+//
+//	... <metadata> <4-byte little-endian length of metadata> "PAR1"
+//
+// so we read the footer with range requests instead of the whole file, and
+// decode just enough of the metadata, which uses the Thrift compact
+// protocol, to summarize the schema.
+//
+func parquetSummary(writer io.Writer, rawUrl string) error {
+	if rawUrl == "-" {
+		return fmt.Errorf("unable to summarize Parquet from standard input, it needs random access")
+	}
+	tail, err := readRange(rawUrl, ByteRange{Offset: -8})
+	if err != nil {
+		return err
+	}
+	if len(tail) != 8 || string(tail[4:]) != "PAR1" {
+		return fmt.Errorf("unable to summarize url %q: not a Parquet file", rawUrl)
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	// Check before reading, because a corrupt length could be up to 4GB
+	if length > maxMetadataLength {
+		return fmt.Errorf("unable to summarize url %q: Parquet footer of %d bytes is too large", rawUrl, length)
+	}
+	footer, err := readRange(rawUrl, ByteRange{Offset: -(length + 8)})
+	if err != nil {
+		return err
+	}
+	if int64(len(footer)) != length+8 {
+		return fmt.Errorf("unable to summarize url %q: truncated Parquet footer", rawUrl)
+	}
+
+	metadata, err := parseParquetMetadata(footer[:length])
+	if err != nil {
+		return fmt.Errorf("unable to summarize url %q: malformed Parquet footer: %w", rawUrl, err)
+	}
+	metadata.write(writer)
+	return nil
+}
+
+func readRange(rawUrl string, byteRange ByteRange) ([]byte, error) {
+	reader, err := OpenRange(rawUrl, byteRange)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+type parquetMetadata struct {
+	numRows   int64
+	rowGroups int
+	createdBy string
+	// The schema, flattened depth-first, starting with the root
+	schema []parquetElement
+}
+
+type parquetElement struct {
+	name        string
+	physical    string
+	logical     string
+	repetition  string
+	numChildren int
+}
+
+var (
+	parquetTypes       = []string{"BOOLEAN", "INT32", "INT64", "INT96", "FLOAT", "DOUBLE", "BYTE_ARRAY", "FIXED_LEN_BYTE_ARRAY"}
+	parquetRepetitions = []string{"required", "optional", "repeated"}
+	parquetConverted   = []string{
+		"UTF8", "MAP", "MAP_KEY_VALUE", "LIST", "ENUM", "DECIMAL", "DATE", "TIME_MILLIS", "TIME_MICROS",
+		"TIMESTAMP_MILLIS", "TIMESTAMP_MICROS", "UINT_8", "UINT_16", "UINT_32", "UINT_64",
+		"INT_8", "INT_16", "INT_32", "INT_64", "JSON", "BSON", "INTERVAL",
+	}
+	// The members of the LogicalType union, by field ID
+	parquetLogical = map[int16]string{
+		1: "STRING", 2: "MAP", 3: "LIST", 4: "ENUM", 5: "DECIMAL", 6: "DATE", 7: "TIME", 8: "TIMESTAMP",
+		10: "INTEGER", 11: "NULL", 12: "JSON", 13: "BSON", 14: "UUID", 15: "FLOAT16",
+	}
+)
+
+func enumName(names []string, value int64) string {
+	if value >= 0 && value < int64(len(names)) {
+		return names[value]
+	}
+	return fmt.Sprint(value)
+}
+
+//
+// Decode the fields of FileMetaData that we need, see parquet.thrift
+//
+func parseParquetMetadata(data []byte) (metadata parquetMetadata, err error) {
+	t := thriftReader{bytes.NewReader(data)}
+	err = t.readStruct(func(id int16, kind byte) error {
+		switch {
+		case id == 2 && kind == thriftList:
+			size, _, err := t.readListHeader()
+			if err != nil {
+				return err
+			}
+			for i := 0; i < size; i++ {
+				element, err := t.readSchemaElement()
+				if err != nil {
+					return err
+				}
+				metadata.schema = append(metadata.schema, element)
+			}
+			return nil
+		case id == 3 && kind == thriftI64:
+			metadata.numRows, err = t.readInt()
+			return err
+		case id == 4 && kind == thriftList:
+			size, elementKind, err := t.readListHeader()
+			if err != nil {
+				return err
+			}
+			metadata.rowGroups = size
+			for i := 0; i < size; i++ {
+				if err := t.skip(elementKind); err != nil {
+					return err
+				}
+			}
+			return nil
+		case id == 6 && kind == thriftBinary:
+			createdBy, err := t.readBinary()
+			metadata.createdBy = string(createdBy)
+			return err
+		}
+		return t.skip(kind)
+	})
+	return metadata, err
+}
+
+func (t thriftReader) readSchemaElement() (element parquetElement, err error) {
+	var converted string
+	err = t.readStruct(func(id int16, kind byte) error {
+		var value int64
+		var err error
+		switch {
+		case id == 1 && kind == thriftI32:
+			value, err = t.readInt()
+			element.physical = enumName(parquetTypes, value)
+		case id == 3 && kind == thriftI32:
+			value, err = t.readInt()
+			element.repetition = enumName(parquetRepetitions, value)
+		case id == 4 && kind == thriftBinary:
+			var name []byte
+			name, err = t.readBinary()
+			element.name = string(name)
+		case id == 5 && kind == thriftI32:
+			value, err = t.readInt()
+			element.numChildren = int(value)
+		case id == 6 && kind == thriftI32:
+			value, err = t.readInt()
+			converted = enumName(parquetConverted, value)
+		case id == 10 && kind == thriftStruct:
+			err = t.readStruct(func(id int16, kind byte) error {
+				element.logical = parquetLogical[id]
+				return t.skip(kind)
+			})
+		default:
+			err = t.skip(kind)
+		}
+		return err
+	})
+	if element.logical == "" {
+		element.logical = converted
+	}
+	return element, err
+}
+
+func (m parquetMetadata) write(writer io.Writer) {
+	fmt.Fprintf(writer, "Parquet: %d rows in %d row groups", m.numRows, m.rowGroups)
+	if m.createdBy != "" {
+		fmt.Fprintf(writer, ", created by %s", m.createdBy)
+	}
+	fmt.Fprintln(writer)
+	if len(m.schema) == 0 {
+		return
+	}
+	fmt.Fprintln(writer, "Schema:")
+	next := 1
+	for i := 0; i < m.schema[0].numChildren && next < len(m.schema); i++ {
+		next = m.writeElement(writer, next, 1)
+	}
+}
+
+//
+// Write the element at index, along with its children, and return the index
+// of the next element at the same level
+//
+func (m parquetMetadata) writeElement(writer io.Writer, index int, depth int) int {
+	element := m.schema[index]
+	kind := element.physical
+	if element.numChildren > 0 {
+		kind = "group"
+	}
+	if element.logical != "" {
+		kind += " " + element.logical
+	}
+	fmt.Fprintf(writer, "%s%s: %s", strings.Repeat("  ", depth), element.name, kind)
+	if element.repetition != "" {
+		fmt.Fprintf(writer, ", %s", element.repetition)
+	}
+	fmt.Fprintln(writer)
+
+	next := index + 1
+	for i := 0; i < element.numChildren && next < len(m.schema); i++ {
+		next = m.writeElement(writer, next, depth+1)
+	}
+	return next
+}
+
+//
+// The types of the Thrift compact protocol
+//
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+//
+// Guard against allocating huge lists and strings for corrupt metadata
+//
+const maxMetadataLength = 1 << 24
+
+//
+// Just enough of the Thrift compact protocol to read Parquet metadata
+//
+type thriftReader struct {
+	reader *bytes.Reader
+}
+
+//
+// Call field for each field of a struct, which must either read the value of
+// the field or skip it
+//
+func (t thriftReader) readStruct(field func(id int16, kind byte) error) error {
+	var last int16
+	for {
+		header, err := t.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		kind := header & 0x0f
+		if kind == thriftStop {
+			return nil
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			value, err := t.readInt()
+			if err != nil {
+				return err
+			}
+			id = int16(value)
+		}
+		last = id
+		if err := field(id, kind); err != nil {
+			return err
+		}
+	}
+}
+
+//
+// Integers of any size are zigzag varints
+//
+func (t thriftReader) readInt() (int64, error) {
+	return binary.ReadVarint(t.reader)
+}
+
+func (t thriftReader) readBinary() ([]byte, error) {
+	length, err := binary.ReadUvarint(t.reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(t.reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(t.reader, data)
+	return data, err
+}
+
+func (t thriftReader) readListHeader() (size int, kind byte, err error) {
+	header, err := t.reader.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size, kind = int(header>>4), header&0x0f
+	if size == 15 {
+		length, err := binary.ReadUvarint(t.reader)
+		if err != nil {
+			return 0, 0, err
+		}
+		if length > maxMetadataLength {
+			return 0, 0, errors.New("list too long")
+		}
+		size = int(length)
+	}
+	return size, kind, nil
+}
+
+func (t thriftReader) skip(kind byte) error {
+	var err error
+	switch kind {
+	case thriftTrue, thriftFalse:
+		// The value of a boolean field is in its type
+	case thriftByte:
+		_, err = t.reader.ReadByte()
+	case thriftI16, thriftI32, thriftI64:
+		_, err = t.readInt()
+	case thriftDouble:
+		_, err = t.reader.Seek(8, io.SeekCurrent)
+	case thriftBinary:
+		_, err = t.readBinary()
+	case thriftList, thriftSet:
+		size, elementKind, err := t.readListHeader()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := t.skipElement(elementKind); err != nil {
+				return err
+			}
+		}
+	case thriftMap:
+		size, err := binary.ReadUvarint(t.reader)
+		if err != nil || size == 0 {
+			return err
+		}
+		if size > maxMetadataLength {
+			return errors.New("map too long")
+		}
+		kinds, err := t.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := t.skipElement(kinds >> 4); err != nil {
+				return err
+			}
+			if err := t.skipElement(kinds & 0x0f); err != nil {
+				return err
+			}
+		}
+	case thriftStruct:
+		err = t.readStruct(func(id int16, kind byte) error {
+			return t.skip(kind)
+		})
+	default:
+		err = fmt.Errorf("unknown Thrift type %d", kind)
+	}
+	return err
+}
+
+//
+// Unlike fields, booleans in lists and maps take up a byte
+//
+func (t thriftReader) skipElement(kind byte) error {
+	if kind == thriftTrue || kind == thriftFalse {
+		_, err := t.reader.ReadByte()
+		return err
+	}
+	return t.skip(kind)
+}
+
+//
+// Avro container files start with a header that has the schema in it, so we
+// can summarize them without reading any further:
+//
+//	"Obj" 0x01 <metadata map> <16-byte sync marker>
+//
+func avroSummary(writer io.Writer, reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(buffered, magic); err != nil || string(magic) != "Obj\x01" {
+		return fmt.Errorf("not an Avro container file")
+	}
+
+	metadata := make(map[string][]byte)
+	for {
+		// Maps come in blocks, each prefixed with the number of entries
+		count, err := binary.ReadVarint(buffered)
+		if err != nil {
+			return fmt.Errorf("malformed Avro header: %w", err)
+		}
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			// A negative count is followed by the size of the block in bytes
+			count = -count
+			if _, err := binary.ReadVarint(buffered); err != nil {
+				return fmt.Errorf("malformed Avro header: %w", err)
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroBytes(buffered)
+			if err != nil {
+				return fmt.Errorf("malformed Avro header: %w", err)
+			}
+			value, err := readAvroBytes(buffered)
+			if err != nil {
+				return fmt.Errorf("malformed Avro header: %w", err)
+			}
+			metadata[string(key)] = value
+		}
+	}
+
+	codec := string(metadata["avro.codec"])
+	if codec == "" {
+		codec = "null"
+	}
+	var schema interface{}
+	if err := json.Unmarshal(metadata["avro.schema"], &schema); err != nil {
+		return fmt.Errorf("malformed Avro schema: %w", err)
+	}
+	fmt.Fprintf(writer, "Avro: codec %s\n", codec)
+	fmt.Fprintf(writer, "Schema: %s\n", avroType(schema))
+	writeAvroFields(writer, schema, 1)
+	return nil
+}
+
+func readAvroBytes(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > maxMetadataLength {
+		return nil, fmt.Errorf("bad length %d", length)
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+//
+// Describe an Avro type in a few words, e.g. "null | string" for a union,
+// or "array<long>"
+//
+func avroType(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case []interface{}:
+		members := make([]string, len(s))
+		for i, member := range s {
+			members[i] = avroType(member)
+		}
+		return strings.Join(members, " | ")
+	case map[string]interface{}:
+		if logicalType, ok := s["logicalType"].(string); ok {
+			return logicalType
+		}
+		switch s["type"] {
+		case "array":
+			return "array<" + avroType(s["items"]) + ">"
+		case "map":
+			return "map<" + avroType(s["values"]) + ">"
+		case "record", "error", "enum", "fixed":
+			return fmt.Sprintf("%s %v", s["type"], s["name"])
+		}
+		return avroType(s["type"])
+	}
+	return fmt.Sprint(schema)
+}
+
+//
+// Write the fields of the records in schema, including any records nested
+// inside them
+//
+func writeAvroFields(writer io.Writer, schema interface{}, depth int) {
+	switch s := schema.(type) {
+	case []interface{}:
+		for _, member := range s {
+			writeAvroFields(writer, member, depth)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "array":
+			writeAvroFields(writer, s["items"], depth)
+		case "map":
+			writeAvroFields(writer, s["values"], depth)
+		case "record", "error":
+			fields, _ := s["fields"].([]interface{})
+			for _, f := range fields {
+				field, ok := f.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(writer, "%s%v: %s\n", strings.Repeat("  ", depth), field["name"], avroType(field["type"]))
+				writeAvroFields(writer, field["type"], depth+1)
+			}
+		}
+	}
+}
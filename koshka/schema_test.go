@@ -0,0 +1,224 @@
+package koshka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//
+// Just enough of a Thrift compact protocol encoder to write Parquet footers
+//
+type thriftWriter struct {
+	bytes.Buffer
+	// The ID of the last field of each struct we're in
+	last []int16
+}
+
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.WriteByte(thriftStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) field(id int16, kind byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		w.WriteByte(kind)
+		w.integer(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) integer(value int64) {
+	w.Write(binary.AppendVarint(nil, value))
+}
+
+func (w *thriftWriter) binary(value string) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	w.WriteString(value)
+}
+
+func (w *thriftWriter) list(size int, kind byte) {
+	w.WriteByte(byte(size)<<4 | kind)
+}
+
+func writeParquetFile(t *testing.T, path string) {
+	var w thriftWriter
+	w.begin()
+	w.field(1, thriftI32)
+	w.integer(1)
+
+	w.field(2, thriftList)
+	w.list(4, thriftStruct)
+	w.begin()
+	w.field(4, thriftBinary)
+	w.binary("schema")
+	w.field(5, thriftI32)
+	w.integer(2)
+	w.end()
+	w.begin()
+	w.field(1, thriftI32)
+	w.integer(2)
+	w.field(3, thriftI32)
+	w.integer(0)
+	w.field(4, thriftBinary)
+	w.binary("id")
+	// An unknown field, far enough along to need the long form of the header
+	w.field(32, thriftDouble)
+	w.Write(make([]byte, 8))
+	w.end()
+	w.begin()
+	w.field(3, thriftI32)
+	w.integer(1)
+	w.field(4, thriftBinary)
+	w.binary("tags")
+	w.field(5, thriftI32)
+	w.integer(1)
+	w.field(6, thriftI32)
+	w.integer(3)
+	w.end()
+	w.begin()
+	w.field(1, thriftI32)
+	w.integer(6)
+	w.field(3, thriftI32)
+	w.integer(2)
+	w.field(4, thriftBinary)
+	w.binary("element")
+	w.field(10, thriftStruct)
+	w.begin()
+	w.field(1, thriftStruct)
+	w.begin()
+	w.end()
+	w.end()
+	w.end()
+
+	w.field(3, thriftI64)
+	w.integer(42)
+
+	w.field(4, thriftList)
+	w.list(2, thriftStruct)
+	w.begin()
+	w.field(2, thriftI64)
+	w.integer(1000)
+	w.field(7, thriftTrue)
+	w.end()
+	w.begin()
+	w.end()
+
+	w.field(5, thriftList)
+	w.list(1, thriftStruct)
+	w.begin()
+	w.field(1, thriftBinary)
+	w.binary("key")
+	w.field(2, thriftBinary)
+	w.binary("value")
+	w.end()
+
+	w.field(6, thriftBinary)
+	w.binary("kot test")
+	w.end()
+
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	file.Write(make([]byte, 100))
+	file.Write(w.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(w.Len())))
+	file.WriteString("PAR1")
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeAvroFile(t *testing.T, path string) {
+	schema := `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "email", "type": ["null", "string"]},
+		{"name": "address", "type": {"type": "record", "name": "Address", "fields": [{"name": "city", "type": "string"}]}},
+		{"name": "created", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}}
+	]}`
+
+	var file bytes.Buffer
+	file.WriteString("Obj\x01")
+	writeBytes := func(value string) {
+		file.Write(binary.AppendVarint(nil, int64(len(value))))
+		file.WriteString(value)
+	}
+	// A block of two entries, with its size in bytes
+	file.Write(binary.AppendVarint(nil, -2))
+	file.Write(binary.AppendVarint(nil, 0))
+	writeBytes("avro.codec")
+	writeBytes("deflate")
+	writeBytes("avro.schema")
+	writeBytes(schema)
+	file.Write(binary.AppendVarint(nil, 0))
+	file.Write(bytes.Repeat([]byte{0xab}, 16))
+	file.Write(make([]byte, 100))
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatWithOptions_schema(t *testing.T) {
+	dir := t.TempDir()
+	parquetPath := filepath.Join(dir, "users.parquet")
+	avroPath := filepath.Join(dir, "users")
+	writeParquetFile(t, parquetPath)
+	writeAvroFile(t, avroPath)
+
+	testCases := []struct {
+		rawUrl   string
+		expected string
+	}{
+		{
+			parquetPath,
+			"Parquet: 42 rows in 2 row groups, created by kot test\n" +
+				"Schema:\n" +
+				"  id: INT64, required\n" +
+				"  tags: group LIST, optional\n" +
+				"    element: BYTE_ARRAY STRING, repeated\n",
+		},
+		{
+			avroPath,
+			"Avro: codec deflate\n" +
+				"Schema: record User\n" +
+				"  id: long\n" +
+				"  email: null | string\n" +
+				"  address: record Address\n" +
+				"    city: string\n" +
+				"  created: timestamp-millis\n" +
+				"  tags: array<string>\n",
+		},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		if err := CatWithOptions(&buffer, tc.rawUrl, CatOptions{Pretty: true}); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.rawUrl, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.rawUrl, tc.expected, actual)
+		}
+	}
+
+	truncated := filepath.Join(dir, "truncated.parquet")
+	os.WriteFile(truncated, []byte("PAR1\xff\xff\x00\x00PAR1"), 0o644)
+	if err := CatWithOptions(&bytes.Buffer{}, truncated, CatOptions{Pretty: true}); err == nil {
+		t.Errorf("expected an error for a truncated footer")
+	}
+
+	huge := filepath.Join(dir, "huge.parquet")
+	os.WriteFile(huge, []byte("PAR1\xff\xff\xff\xffPAR1"), 0o644)
+	err := CatWithOptions(&bytes.Buffer{}, huge, CatOptions{Pretty: true})
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected an error for a huge footer, got %v", err)
+	}
+}
@@ -0,0 +1,444 @@
+package koshka
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type sftpBackend struct{}
+
+func init() {
+	Register("sftp", sftpBackend{})
+	Register("ssh", sftpBackend{})
+}
+
+func (sftpBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	client, path, err := sftp_connect(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return client.Open(path)
+}
+
+func (sftpBackend) OpenRange(rawUrl string, byteRange ByteRange) (io.ReadCloser, error) {
+	client, path, err := sftp_connect(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(file, byteRange)
+}
+
+func (sftpBackend) Create(rawUrl string) (io.WriteCloser, error) {
+	client, path, err := sftp_connect(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like local files, write next to the destination and rename at the end,
+	// so that a failed upload doesn't leave a truncated file behind
+	slash := strings.LastIndex(path, "/")
+	temp := fmt.Sprintf("%s.%s.kot-%d", path[:slash+1], path[slash+1:], time.Now().UnixNano())
+	file, err := client.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write to url %q: %w", rawUrl, err)
+	}
+	if info, err := client.Stat(path); err == nil {
+		file.Chmod(info.Mode().Perm())
+	}
+	return &sftpWriter{file, client, path}, nil
+}
+
+type sftpWriter struct {
+	*sftp.File
+	client *sftp.Client
+	path   string
+}
+
+func (w *sftpWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		w.client.Remove(w.Name())
+		return err
+	}
+	// Plain SFTP rename refuses to replace an existing file
+	if err := w.client.PosixRename(w.Name(), w.path); err != nil {
+		w.client.Remove(w.Name())
+		return err
+	}
+	return nil
+}
+
+//
+// Abandon the upload, leaving whatever was at the path before untouched
+//
+func (w *sftpWriter) CloseWithError(err error) error {
+	w.File.Close()
+	return w.client.Remove(w.Name())
+}
+
+func (sftpBackend) Remove(rawUrl string) error {
+	client, path, err := sftp_connect(rawUrl)
+	if err != nil {
+		return err
+	}
+	return client.Remove(path)
+}
+
+func (sftpBackend) Stat(rawUrl string) (ObjectInfo, error) {
+	client, path, err := sftp_connect(rawUrl)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := client.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("unable to stat url %q: %w", rawUrl, err)
+	}
+	return ObjectInfo{
+		Url:     rawUrl,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}, nil
+}
+
+func (sftpBackend) List(prefix string) ([]string, error) {
+	return sftp_list(prefix)
+}
+
+//
+// Split sftp://user@host:port/path into the part before the path, and the
+// path.  We take paths literally, without any percent-decoding, and a path
+// that starts with /~/ is relative to the home directory, e.g.
+// sftp://bastion/~/logs/app.log
+//
+func sftp_split(rawUrl string) (root, path string, err error) {
+	scheme := schemeOf(rawUrl)
+	rest := rawUrl[len(scheme)+1:]
+	// Be lenient with partially typed URLs like sftp: and sftp:/
+	if rest == "" || rest == "/" {
+		return rawUrl[:len(scheme)+1] + "//", "", nil
+	}
+	rest, ok := strings.CutPrefix(rest, "//")
+	if !ok {
+		return "", "", fmt.Errorf("malformed %s url: %q", scheme, rawUrl)
+	}
+	authority, path, found := strings.Cut(rest, "/")
+	if found {
+		path = "/" + path
+	}
+	return rawUrl[:len(scheme)+3+len(authority)], path, nil
+}
+
+//
+// Translate the path of the URL to the path on the server
+//
+func sftp_remote_path(path string) string {
+	if path == "/~" || path == "/~/" {
+		return "."
+	}
+	if relative, ok := strings.CutPrefix(path, "/~/"); ok {
+		return relative
+	}
+	return path
+}
+
+//
+// Load ~/.ssh/config, so that we can use the same host aliases as ssh does
+//
+func sftp_ssh_config() *ssh_config.Config {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	fin, err := os.Open(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return nil
+	}
+	defer fin.Close()
+	sshConfig, err := ssh_config.Decode(fin)
+	if err != nil {
+		return nil
+	}
+	return sshConfig
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return os.ExpandEnv(path)
+}
+
+var (
+	sftpClientsMutex sync.Mutex
+	sftpClients      = make(map[string]*sftp.Client)
+)
+
+//
+// Connect to the server for rawUrl, and return the client along with the path
+// on the server.  Host aliases, users, ports, identity files and known hosts
+// come from ~/.ssh/config, and the matching sections of kot.cfg may override
+// them:
+//
+//	[sftp://bastion]
+//	username = deploy
+//	password = nonono                  # if there's no key for this host
+//	identity_file = ~/.ssh/deploy_ed25519
+//	known_hosts = ~/.ssh/known_hosts
+//
+// We also try the keys in ssh-agent, and the default identity files.  Unknown
+// host keys are an error, so ssh to the host once to add its key.
+//
+func sftp_connect(rawUrl string) (*sftp.Client, string, error) {
+	root, path, err := sftp_split(rawUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	authority := root[len(schemeOf(rawUrl))+3:]
+	user, alias, hasUser := strings.Cut(authority, "@")
+	if !hasUser {
+		user, alias = "", authority
+	}
+	port := ""
+	if host, p, err := net.SplitHostPort(alias); err == nil {
+		alias, port = host, p
+	}
+
+	sshConfig := sftp_ssh_config()
+	get := func(key string) string {
+		if sshConfig == nil {
+			return ""
+		}
+		value, _ := sshConfig.Get(alias, key)
+		return value
+	}
+	kotConfig, err := findConfig(rawUrl, "")
+	if err != nil {
+		kotConfig = map[string]string{}
+	}
+
+	hostName := get("HostName")
+	if hostName == "" {
+		hostName = alias
+	}
+	if port == "" {
+		port = get("Port")
+	}
+	if port == "" {
+		port = "22"
+	}
+	for _, candidate := range []string{user, kotConfig["username"], get("User"), os.Getenv("USER")} {
+		if user = candidate; user != "" {
+			break
+		}
+	}
+	address := net.JoinHostPort(hostName, port)
+
+	delete(kotConfig, "alias")
+	cacheKey := fmt.Sprint(user, "@", address, kotConfig)
+	sftpClientsMutex.Lock()
+	defer sftpClientsMutex.Unlock()
+	if client, ok := sftpClients[cacheKey]; ok {
+		return client, sftp_remote_path(path), nil
+	}
+
+	var auth []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			// The agent is only needed to authenticate, not afterwards
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	identityFiles := []string{kotConfig["identity_file"]}
+	if sshConfig != nil {
+		values, _ := sshConfig.GetAll(alias, "IdentityFile")
+		identityFiles = append(identityFiles, values...)
+	}
+	identityFiles = append(identityFiles, "~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa")
+	var signers []ssh.Signer
+	for _, identityFile := range identityFiles {
+		if identityFile == "" {
+			continue
+		}
+		// Skip keys that are missing, or encrypted, e.g. because they're in the agent
+		pem, err := os.ReadFile(expandHome(identityFile))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(pem); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if password, ok := kotConfig["password"]; ok {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	knownHosts := kotConfig["known_hosts"]
+	if knownHosts == "" {
+		knownHosts = get("UserKnownHostsFile")
+	}
+	if fields := strings.Fields(knownHosts); len(fields) > 0 {
+		knownHosts = fields[0]
+	} else {
+		knownHosts = "~/.ssh/known_hosts"
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHosts))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to load known hosts for url %q: %w", rawUrl, err)
+	}
+
+	sshClient, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:              user,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: sftp_host_key_algorithms(hostKeyCallback, address),
+		Timeout:           30 * time.Second,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to %s for url %q: %w", address, rawUrl, err)
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, "", fmt.Errorf("unable to start sftp for url %q: %w", rawUrl, err)
+	}
+	sftpClients[cacheKey] = client
+	return client, sftp_remote_path(path), nil
+}
+
+//
+// The host key algorithms for the keys that known_hosts has for address.
+// Otherwise, the server may offer e.g. its ECDSA key when known_hosts only
+// has the ed25519 one, and the handshake fails with a key mismatch.  Nil
+// means the defaults, e.g. for unknown hosts.
+//
+func sftp_host_key_algorithms(hostKeyCallback ssh.HostKeyCallback, address string) (algorithms []string) {
+	//
+	// There's no way to look the keys up directly, but checking a key that
+	// can't be known fails with a KeyError listing the ones that are
+	//
+	var keyErr *knownhosts.KeyError
+	err := hostKeyCallback(address, &net.TCPAddr{IP: net.IPv4zero}, unknownKey{})
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+type unknownKey struct{}
+
+func (unknownKey) Type() string                        { return "kot-unknown" }
+func (unknownKey) Marshal() []byte                     { return []byte("kot-unknown") }
+func (unknownKey) Verify([]byte, *ssh.Signature) error { return fmt.Errorf("not a real key") }
+
+func sftp_list(prefix string) (candidates []string, err error) {
+	root, path, err := sftp_split(prefix)
+	if err != nil {
+		return candidates, err
+	}
+	if !strings.HasPrefix(path, "/") {
+		return sftp_list_hosts(root)
+	}
+	if path == "/~" {
+		return []string{root + "/~/"}, nil
+	}
+
+	client, _, err := sftp_connect(prefix)
+	if err != nil {
+		return candidates, err
+	}
+
+	slash := strings.LastIndex(path, "/")
+	dir, base := path[:slash+1], path[slash+1:]
+	remoteDir := sftp_remote_path(dir)
+	entries, err := client.ReadDir(remoteDir)
+	if err != nil {
+		return candidates, fmt.Errorf("unable to list url %q: %w", prefix, err)
+	}
+	// Unlike os.ReadDir, the server returns the entries in no particular order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		candidate := root + dir + name
+		isDir := entry.IsDir()
+		if entry.Mode()&os.ModeSymlink != 0 {
+			info, err := client.Stat(client.Join(remoteDir, name))
+			isDir = err == nil && info.IsDir()
+		}
+		if isDir {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+//
+// Complete the host from the aliases in ~/.ssh/config, e.g. sftp://bas
+// becomes sftp://bastion/
+//
+func sftp_list_hosts(root string) (candidates []string, err error) {
+	sshConfig := sftp_ssh_config()
+	if sshConfig == nil {
+		return candidates, nil
+	}
+	scheme := schemeOf(root)
+	user, typed, hasUser := strings.Cut(root[len(scheme)+3:], "@")
+	if !hasUser {
+		user, typed = "", user
+	}
+
+	for _, host := range sshConfig.Hosts {
+		for _, pattern := range host.Patterns {
+			alias := pattern.String()
+			if strings.ContainsAny(alias, "*?!") || !strings.HasPrefix(alias, typed) {
+				continue
+			}
+			candidate := scheme + "://"
+			if hasUser {
+				candidate += user + "@"
+			}
+			candidates = append(candidates, candidate+alias+"/")
+		}
+	}
+	return candidates, nil
+}
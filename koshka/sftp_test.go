@@ -0,0 +1,242 @@
+package koshka
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//
+// Run an SSH server with the sftp subsystem on a random port, serving the
+// local filesystem with dir as the home directory, and point ~/.ssh/config
+// and kot.cfg at it under the alias "bastion".  The server has an ed25519
+// and an ECDSA host key, but known_hosts only has the ed25519 one.
+//
+func setupFakeSftp(t *testing.T, dir string) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "kot" && string(password) == "meow" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied for %q", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHostKey, err := ssh.NewSignerFromKey(ecdsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig.AddHostKey(otherHostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, serverConfig, dir)
+		}
+	}()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(sshDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sshConfig := fmt.Sprintf("Host bastion\n  HostName %s\n  Port %s\n  User kot\n\nHost *.internal\n  User nobody\n", host, port)
+	knownHosts := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostKey.PublicKey())
+	for name, contents := range map[string]string{
+		filepath.Join(sshDir, "config"):      sshConfig,
+		filepath.Join(sshDir, "known_hosts"): knownHosts + "\n",
+		filepath.Join(home, "kot.cfg"):       "[sftp://bastion]\npassword = meow\n",
+	} {
+		if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serveSftp(conn net.Conn, serverConfig *ssh.ServerConfig, dir string) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range channelRequests {
+				isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(isSftp, nil)
+				if !isSftp {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					channel.Close()
+					return
+				}
+				go func() {
+					server.Serve()
+					channel.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func Test_sftp_list(t *testing.T) {
+	dir := t.TempDir()
+	setupFakeSftp(t, dir)
+	for _, name := range []string{"logs/app.log", "logs/app.log.1", "notes.txt", ".hidden"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"sftp://bas", []string{"sftp://bastion/"}},
+		{"ssh://root@b", []string{"ssh://root@bastion/"}},
+		{"sftp://bastion/~", []string{"sftp://bastion/~/"}},
+		{"sftp://bastion/~/", []string{"sftp://bastion/~/logs/", "sftp://bastion/~/notes.txt"}},
+		{"sftp://bastion/~/logs/app", []string{"sftp://bastion/~/logs/app.log", "sftp://bastion/~/logs/app.log.1"}},
+		{"sftp://bastion" + dir + "/n", []string{"sftp://bastion" + dir + "/notes.txt"}},
+		{"sftp://bastion" + dir + "/.h", []string{"sftp://bastion" + dir + "/.hidden"}},
+	}
+	for _, tc := range testCases {
+		actual, err := sftp_list(tc.prefix)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.prefix, err)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc.prefix, tc.expected, actual)
+		}
+	}
+}
+
+func Test_sftp_open(t *testing.T) {
+	dir := t.TempDir()
+	setupFakeSftp(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "motd"), []byte("line 1\nline 2\nline 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		rawUrl   string
+		options  CatOptions
+		expected string
+	}{
+		{"sftp://bastion/~/motd", CatOptions{}, "line 1\nline 2\nline 3\n"},
+		{"sftp://bastion" + dir + "/motd", CatOptions{HeadLines: 1}, "line 1\n"},
+		{"sftp://bastion/~/motd", CatOptions{TailLines: 2}, "line 2\nline 3\n"},
+		{"sftp://bastion/~/motd", CatOptions{Range: &ByteRange{Offset: 5, Length: 3}}, "1\nl"},
+	}
+	for _, tc := range testCases {
+		var buffer bytes.Buffer
+		if err := CatWithOptions(&buffer, tc.rawUrl, tc.options); err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.rawUrl, err)
+		}
+		if actual := buffer.String(); actual != tc.expected {
+			t.Errorf("tc: %q expected %q, got %q", tc.rawUrl, tc.expected, actual)
+		}
+	}
+
+	if _, err := Open("sftp://nobody@bastion/~/motd"); err == nil {
+		t.Errorf("expected the wrong user to be denied access")
+	}
+}
+
+func TestPut_sftp(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, ".cache"))
+	setupFakeSftp(t, dir)
+
+	rawUrl := "sftp://bastion/~/uploaded.txt"
+	if err := Put(rawUrl, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	info, err := Stat(rawUrl)
+	if err != nil || info.Size != 5 {
+		t.Errorf("expected a 5 byte object, got %v (%v)", info, err)
+	}
+
+	reader, err := Open(rawUrl)
+	if err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	actual, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(actual) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", actual)
+	}
+
+	// Overwriting replaces the file, and a failed upload leaves it alone
+	if err := Put(rawUrl, strings.NewReader("hello again")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := Put(rawUrl, failing); err == nil {
+		t.Errorf("expected a failing reader to fail the upload")
+	}
+	if actual, _ := os.ReadFile(filepath.Join(dir, "uploaded.txt")); string(actual) != "hello again" {
+		t.Errorf("expected %q, got %q", "hello again", actual)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".uploaded.txt.kot-*")); len(matches) > 0 {
+		t.Errorf("expected no temporary files to be left behind, got %q", matches)
+	}
+
+	if err := Remove(rawUrl); err != nil {
+		t.Errorf("unexpected err: %q", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uploaded.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed", rawUrl)
+	}
+}
@@ -0,0 +1,841 @@
+package koshka
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//
+// A client-side evaluator for the subset of the S3 Select SQL dialect that we
+// use most, so that the same queries work for any backend:
+//
+//	SELECT * | COUNT(*) | expr [AS name], ...
+//	FROM S3Object [[AS] alias]
+//	[WHERE condition]
+//	[LIMIT n]
+//
+// Expressions are column references like name, s.name, s."Quoted Name",
+// s.nested.field and _1 (the first column), string and number literals, TRUE,
+// FALSE, NULL, comparisons, AND, OR, NOT, [NOT] LIKE, [NOT] IN (...),
+// [NOT] BETWEEN, IS [NOT] NULL, and the functions CAST, LOWER and UPPER.
+// Unquoted names are case-insensitive.
+//
+type sqlQuery struct {
+	// items is nil for SELECT *
+	items []sqlItem
+	count bool
+	alias string
+	where sqlExpr
+	limit int
+}
+
+type sqlItem struct {
+	expr sqlExpr
+	name string
+}
+
+//
+// A record is a row of a CSV file, or an object of a JSON file, with the
+// fields in their original order
+//
+type sqlRecord struct {
+	names  []string
+	values []interface{}
+}
+
+func (r *sqlRecord) get(name string, quoted bool) (interface{}, bool) {
+	for i, n := range r.names {
+		if n == name || (!quoted && strings.EqualFold(n, name)) {
+			return r.values[i], true
+		}
+	}
+	return nil, false
+}
+
+type sqlExpr interface {
+	eval(r *sqlRecord) (interface{}, error)
+}
+
+type sqlLiteral struct {
+	value interface{}
+}
+
+func (e sqlLiteral) eval(*sqlRecord) (interface{}, error) {
+	return e.value, nil
+}
+
+type sqlColumn struct {
+	path   []string
+	quoted []bool
+}
+
+func (e sqlColumn) eval(r *sqlRecord) (interface{}, error) {
+	if index, ok := positionalColumn(e.path[0], e.quoted[0]); ok && len(e.path) == 1 {
+		if index < len(r.values) {
+			return r.values[index], nil
+		}
+		return nil, nil
+	}
+
+	value, ok := r.get(e.path[0], e.quoted[0])
+	if !ok {
+		return nil, nil
+	}
+	for i := 1; i < len(e.path) && value != nil; i++ {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		value = nil
+		for key, v := range object {
+			if key == e.path[i] || (!e.quoted[i] && strings.EqualFold(key, e.path[i])) {
+				value = v
+				break
+			}
+		}
+	}
+	return value, nil
+}
+
+//
+// S3 Select calls the columns _1, _2, etc.
+//
+func positionalColumn(name string, quoted bool) (int, bool) {
+	if quoted || !strings.HasPrefix(name, "_") {
+		return 0, false
+	}
+	index, err := strconv.Atoi(name[1:])
+	if err != nil || index < 1 {
+		return 0, false
+	}
+	return index - 1, true
+}
+
+type sqlBinary struct {
+	op          string
+	left, right sqlExpr
+}
+
+func (e sqlBinary) eval(r *sqlRecord) (interface{}, error) {
+	left, err := e.left.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit, with NULL meaning unknown, as in SQL
+	switch e.op {
+	case "AND":
+		if left == false {
+			return false, nil
+		}
+	case "OR":
+		if left == true {
+			return true, nil
+		}
+	}
+
+	right, err := e.right.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "AND", "OR":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return right == true, nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	cmp, ok := sqlCompare(left, right)
+	if !ok {
+		return e.op == "!=", nil
+	}
+	switch e.op {
+	case "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", e.op)
+}
+
+//
+// Compare two non-NULL values.  CSV fields are always strings, so compare
+// them as numbers when the other side is a number, as if they'd been CAST.
+// This is more lenient than S3 Select, which compares CSV fields as strings
+// unless the query CASTs them, so e.g. age > 30 works here, but only
+// CAST(age AS INT) > 30 works on both.  Two strings always compare as
+// strings, e.g. '9' > '10', like they do in S3 Select.
+//
+func sqlCompare(a, b interface{}) (int, bool) {
+	if x, ok := sqlNumber(a); ok {
+		if y, ok := sqlNumber(b); ok {
+			_, aIsString := a.(string)
+			_, bIsString := b.(string)
+			if !aIsString || !bIsString {
+				switch {
+				case x < y:
+					return -1, true
+				case x > y:
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func sqlNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+type sqlNot struct {
+	expr sqlExpr
+}
+
+func (e sqlNot) eval(r *sqlRecord) (interface{}, error) {
+	value, err := e.expr.eval(r)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return value != true, nil
+}
+
+type sqlIsNull struct {
+	expr sqlExpr
+	not  bool
+}
+
+func (e sqlIsNull) eval(r *sqlRecord) (interface{}, error) {
+	value, err := e.expr.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	return (value == nil) != e.not, nil
+}
+
+type sqlLike struct {
+	expr    sqlExpr
+	pattern *regexp.Regexp
+	not     bool
+}
+
+func (e sqlLike) eval(r *sqlRecord) (interface{}, error) {
+	value, err := e.expr.eval(r)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return e.pattern.MatchString(sqlString(value)) != e.not, nil
+}
+
+//
+// Translate a LIKE pattern, where % matches anything and _ matches a single
+// character, to a regular expression
+//
+func likePattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+type sqlIn struct {
+	expr sqlExpr
+	list []sqlExpr
+	not  bool
+}
+
+func (e sqlIn) eval(r *sqlRecord) (interface{}, error) {
+	value, err := e.expr.eval(r)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	for _, item := range e.list {
+		candidate, err := item.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		if cmp, ok := sqlCompare(value, candidate); ok && cmp == 0 {
+			return !e.not, nil
+		}
+	}
+	return e.not, nil
+}
+
+type sqlFunction struct {
+	name string
+	arg  sqlExpr
+	// the type to CAST to
+	as string
+}
+
+func (e sqlFunction) eval(r *sqlRecord) (interface{}, error) {
+	value, err := e.arg.eval(r)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	switch e.name {
+	case "LOWER":
+		return strings.ToLower(sqlString(value)), nil
+	case "UPPER":
+		return strings.ToUpper(sqlString(value)), nil
+	}
+
+	switch e.as {
+	case "STRING", "VARCHAR", "CHAR":
+		return sqlString(value), nil
+	case "BOOL", "BOOLEAN":
+		b, err := strconv.ParseBool(sqlString(value))
+		if err != nil {
+			return nil, fmt.Errorf("unable to CAST %q AS %s", sqlString(value), e.as)
+		}
+		return b, nil
+	}
+	number, ok := sqlNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("unable to CAST %q AS %s", sqlString(value), e.as)
+	}
+	if e.as == "INT" || e.as == "INTEGER" {
+		number = float64(int64(number))
+	}
+	return number, nil
+}
+
+//
+// The text of a value, as it would appear in a CSV file
+//
+func sqlString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+//
+// Select the output fields for a record, or return nil if the record doesn't
+// match the WHERE clause
+//
+func (q *sqlQuery) project(r *sqlRecord) (*sqlRecord, error) {
+	if q.where != nil {
+		matches, err := q.where.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		if matches != true {
+			return nil, nil
+		}
+	}
+	if q.items == nil {
+		return r, nil
+	}
+	result := &sqlRecord{}
+	for _, item := range q.items {
+		value, err := item.expr.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		result.names = append(result.names, item.name)
+		result.values = append(result.values, value)
+	}
+	return result, nil
+}
+
+type sqlToken struct {
+	// kind is one of: ident, quoted, string, number, op, end
+	kind string
+	text string
+}
+
+func tokenizeSql(text string) (tokens []sqlToken, err error) {
+	i := 0
+	for i < len(text) {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(text); j++ {
+				if text[j] == byte(c) {
+					// A doubled quote stands for itself
+					if j+1 < len(text) && text[j+1] == byte(c) {
+						value.WriteByte(byte(c))
+						j++
+						continue
+					}
+					break
+				}
+				value.WriteByte(text[j])
+			}
+			if j == len(text) {
+				return nil, fmt.Errorf("unterminated quote in query: %q", text)
+			}
+			kind := "string"
+			if c == '"' {
+				kind = "quoted"
+			}
+			tokens = append(tokens, sqlToken{kind, value.String()})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(text) && unicode.IsDigit(rune(text[i+1]))):
+			j := i
+			for j < len(text) && (unicode.IsDigit(rune(text[j])) || text[j] == '.' || text[j] == 'e' || text[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{"number", text[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(text) && (unicode.IsLetter(rune(text[j])) || unicode.IsDigit(rune(text[j])) || text[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{"ident", text[i:j]})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(text) {
+				switch two := text[i : i+2]; two {
+				case "<=", ">=", "!=", "<>":
+					op = two
+				}
+			}
+			if !strings.Contains("=<>!(),*.-", op[:1]) {
+				return nil, fmt.Errorf("unexpected %q in query: %q", op, text)
+			}
+			if op == "<>" {
+				tokens = append(tokens, sqlToken{"op", "!="})
+			} else {
+				tokens = append(tokens, sqlToken{"op", op})
+			}
+			i += len(op)
+		}
+	}
+	return append(tokens, sqlToken{"end", ""}), nil
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	next   int
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.next]
+}
+
+func (p *sqlParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == "ident" && strings.EqualFold(token.text, keyword)
+}
+
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) acceptOp(op string) bool {
+	if token := p.peek(); token.kind == "op" && token.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) unexpected() error {
+	token := p.peek()
+	if token.kind == "end" {
+		return fmt.Errorf("unexpected end of query")
+	}
+	return fmt.Errorf("unexpected %q in query", token.text)
+}
+
+//
+// Parse a query, e.g. SELECT s.name FROM S3Object s WHERE s.age > 30
+//
+func parseSql(text string) (*sqlQuery, error) {
+	tokens, err := tokenizeSql(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens}
+	query := &sqlQuery{limit: -1}
+
+	if !p.acceptKeyword("SELECT") {
+		return nil, p.unexpected()
+	}
+	switch {
+	case p.acceptOp("*"):
+	case p.isKeyword("COUNT") && p.tokens[p.next+1].text == "(" && p.tokens[p.next+2].text == "*":
+		p.next += 3
+		if !p.acceptOp(")") {
+			return nil, p.unexpected()
+		}
+		query.count = true
+	default:
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := sqlItem{expr: expr, name: fmt.Sprintf("_%d", len(query.items)+1)}
+			if column, ok := expr.(sqlColumn); ok {
+				item.name = column.path[len(column.path)-1]
+			}
+			if p.acceptKeyword("AS") || (p.peek().kind == "ident" && !p.isKeyword("FROM")) || p.peek().kind == "quoted" {
+				if token := p.peek(); token.kind != "ident" && token.kind != "quoted" {
+					return nil, p.unexpected()
+				}
+				item.name = p.peek().text
+				p.next++
+			}
+			query.items = append(query.items, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if !p.acceptKeyword("FROM") {
+		return nil, p.unexpected()
+	}
+	if !p.acceptKeyword("S3Object") {
+		return nil, fmt.Errorf("only FROM S3Object is supported")
+	}
+	// Selecting from a path within the document, e.g. S3Object[*].items, isn't supported
+	p.acceptKeyword("AS")
+	if token := p.peek(); token.kind == "ident" && !p.isKeyword("WHERE") && !p.isKeyword("LIMIT") {
+		query.alias = token.text
+		p.next++
+	}
+
+	if p.acceptKeyword("WHERE") {
+		if query.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		token := p.peek()
+		limit, err := strconv.Atoi(token.text)
+		if token.kind != "number" || err != nil || limit < 0 {
+			return nil, fmt.Errorf("malformed LIMIT: %q", token.text)
+		}
+		query.limit = limit
+		p.next++
+	}
+	if p.peek().kind != "end" {
+		return nil, p.unexpected()
+	}
+
+	query.stripAlias()
+	return query, nil
+}
+
+//
+// Column references may start with the alias of the table, e.g. s.name, or
+// S3Object.name, and we don't need that part to find the column
+//
+func (q *sqlQuery) stripAlias() {
+	var strip func(expr sqlExpr) sqlExpr
+	strip = func(expr sqlExpr) sqlExpr {
+		switch e := expr.(type) {
+		case sqlColumn:
+			first := e.path[0]
+			isAlias := strings.EqualFold(first, "S3Object") || (q.alias != "" && strings.EqualFold(first, q.alias))
+			if len(e.path) > 1 && !e.quoted[0] && isAlias {
+				return sqlColumn{path: e.path[1:], quoted: e.quoted[1:]}
+			}
+		case sqlBinary:
+			return sqlBinary{e.op, strip(e.left), strip(e.right)}
+		case sqlNot:
+			return sqlNot{strip(e.expr)}
+		case sqlIsNull:
+			return sqlIsNull{strip(e.expr), e.not}
+		case sqlLike:
+			return sqlLike{strip(e.expr), e.pattern, e.not}
+		case sqlIn:
+			list := make([]sqlExpr, len(e.list))
+			for i, item := range e.list {
+				list[i] = strip(item)
+			}
+			return sqlIn{strip(e.expr), list, e.not}
+		case sqlFunction:
+			return sqlFunction{e.name, strip(e.arg), e.as}
+		}
+		return expr
+	}
+	for i := range q.items {
+		q.items[i].expr = strip(q.items[i].expr)
+	}
+	if q.where != nil {
+		q.where = strip(q.where)
+	}
+}
+
+func (p *sqlParser) parseExpr() (sqlExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = sqlBinary{"OR", left, right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) parseAnd() (sqlExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = sqlBinary{"AND", left, right}
+	}
+	return left, nil
+}
+
+func (p *sqlParser) parseNot() (sqlExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return sqlNot{expr}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *sqlParser) parsePredicate() (sqlExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"=", "!=", "<=", ">=", "<", ">"} {
+		if p.acceptOp(op) {
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return sqlBinary{op, left, right}, nil
+		}
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if !p.acceptKeyword("NULL") {
+			return nil, p.unexpected()
+		}
+		return sqlIsNull{left, not}, nil
+	}
+
+	not := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("LIKE"):
+		token := p.peek()
+		if token.kind != "string" {
+			return nil, fmt.Errorf("LIKE needs a string pattern, got %q", token.text)
+		}
+		p.next++
+		pattern, err := likePattern(token.text)
+		if err != nil {
+			return nil, err
+		}
+		return sqlLike{left, pattern, not}, nil
+	case p.acceptKeyword("IN"):
+		if !p.acceptOp("(") {
+			return nil, p.unexpected()
+		}
+		var list []sqlExpr
+		for {
+			item, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if !p.acceptOp(")") {
+			return nil, p.unexpected()
+		}
+		return sqlIn{left, list, not}, nil
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("AND") {
+			return nil, p.unexpected()
+		}
+		high, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		var between sqlExpr = sqlBinary{"AND", sqlBinary{">=", left, low}, sqlBinary{"<=", left, high}}
+		if not {
+			between = sqlNot{between}
+		}
+		return between, nil
+	case not:
+		return nil, p.unexpected()
+	}
+	return left, nil
+}
+
+func (p *sqlParser) parsePrimary() (sqlExpr, error) {
+	token := p.peek()
+	switch {
+	case token.kind == "string":
+		p.next++
+		return sqlLiteral{token.text}, nil
+	case token.kind == "number":
+		p.next++
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed number %q in query", token.text)
+		}
+		return sqlLiteral{number}, nil
+	case token.kind == "op" && token.text == "-":
+		p.next++
+		expr, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := expr.(sqlLiteral)
+		if number, isNumber := literal.value.(float64); ok && isNumber {
+			return sqlLiteral{-number}, nil
+		}
+		return nil, fmt.Errorf("only numbers can be negated")
+	case token.kind == "op" && token.text == "(":
+		p.next++
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptOp(")") {
+			return nil, p.unexpected()
+		}
+		return expr, nil
+	case p.acceptKeyword("TRUE"):
+		return sqlLiteral{true}, nil
+	case p.acceptKeyword("FALSE"):
+		return sqlLiteral{false}, nil
+	case p.acceptKeyword("NULL"):
+		return sqlLiteral{nil}, nil
+	case token.kind == "ident" && p.tokens[p.next+1].text == "(" && p.tokens[p.next+1].kind == "op":
+		return p.parseFunction()
+	case token.kind == "ident" || token.kind == "quoted":
+		var column sqlColumn
+		for {
+			token := p.peek()
+			if token.kind != "ident" && token.kind != "quoted" {
+				return nil, p.unexpected()
+			}
+			column.path = append(column.path, token.text)
+			column.quoted = append(column.quoted, token.kind == "quoted")
+			p.next++
+			if !p.acceptOp(".") {
+				return column, nil
+			}
+		}
+	}
+	return nil, p.unexpected()
+}
+
+func (p *sqlParser) parseFunction() (sqlExpr, error) {
+	name := strings.ToUpper(p.peek().text)
+	p.next += 2
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	function := sqlFunction{name: name, arg: arg}
+	switch name {
+	case "LOWER", "UPPER":
+	case "CAST":
+		if !p.acceptKeyword("AS") || p.peek().kind != "ident" {
+			return nil, p.unexpected()
+		}
+		function.as = strings.ToUpper(p.peek().text)
+		p.next++
+		switch function.as {
+		case "INT", "INTEGER", "FLOAT", "DECIMAL", "NUMERIC", "STRING", "VARCHAR", "CHAR", "BOOL", "BOOLEAN":
+		default:
+			return nil, fmt.Errorf("unsupported type in CAST: %s", function.as)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported function in query: %s", name)
+	}
+	if !p.acceptOp(")") {
+		return nil, p.unexpected()
+	}
+	return function, nil
+}
@@ -0,0 +1,113 @@
+package koshka
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+)
+
+//
+// The checksums that we know how to verify, by the names that backends use
+// for them in ObjectInfo.Checksums
+//
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha256": sha256.New,
+}
+
+//
+// ErrChecksumMismatch means that what we read from an object doesn't match
+// the checksum stored along with it, e.g. because of a corrupted download.
+//
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+//
+// Checksums usually come base64-encoded in headers, but we show them in hex,
+// like md5sum and friends do
+//
+func base64ToHex(encoded string) string {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(decoded)
+}
+
+//
+// Hashes everything read through it, including whatever is left unread at
+// the end, and compares the results with the stored checksums
+//
+type checksumVerifier struct {
+	rawUrl   string
+	expected map[string]string
+	hashes   map[string]hash.Hash
+	reader   io.Reader
+}
+
+func newChecksumVerifier(rawUrl string) (*checksumVerifier, error) {
+	info, err := Stat(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	verifier := &checksumVerifier{
+		rawUrl:   rawUrl,
+		expected: make(map[string]string),
+		hashes:   make(map[string]hash.Hash),
+	}
+	for algorithm, checksum := range info.Checksums {
+		if newHash, ok := checksumAlgorithms[algorithm]; ok && checksum != "" {
+			verifier.expected[algorithm] = strings.ToLower(checksum)
+			verifier.hashes[algorithm] = newHash()
+		}
+	}
+	if len(verifier.hashes) == 0 {
+		return nil, fmt.Errorf("unable to verify url %q: no stored MD5, CRC32C or SHA256 checksum", rawUrl)
+	}
+	return verifier, nil
+}
+
+func (v *checksumVerifier) wrap(reader io.ReadCloser) io.ReadCloser {
+	var writers []io.Writer
+	for _, h := range v.hashes {
+		writers = append(writers, h)
+	}
+	v.reader = io.TeeReader(reader, io.MultiWriter(writers...))
+	return struct {
+		io.Reader
+		io.Closer
+	}{v.reader, reader}
+}
+
+func (v *checksumVerifier) verify() error {
+	if _, err := io.Copy(io.Discard, v.reader); err != nil {
+		return fmt.Errorf("unable to read stream from url %q: %w", v.rawUrl, err)
+	}
+	algorithms := make([]string, 0, len(v.hashes))
+	for algorithm := range v.hashes {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	for _, algorithm := range algorithms {
+		actual := hex.EncodeToString(v.hashes[algorithm].Sum(nil))
+		if actual != v.expected[algorithm] {
+			return fmt.Errorf(
+				"unable to verify url %q: %w: expected %s %s, got %s",
+				v.rawUrl,
+				ErrChecksumMismatch,
+				algorithm,
+				v.expected[algorithm],
+				actual,
+			)
+		}
+	}
+	return nil
+}
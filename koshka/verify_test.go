@@ -0,0 +1,52 @@
+package koshka
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCatWithOptions_verify(t *testing.T) {
+	f := &fakeS3{bucket: "bucket", pageSize: 1000}
+	setupFakeS3(t, f, "")
+	contents := "line 1\nline 2\n"
+	if err := Put("s3://bucket/data.txt", strings.NewReader(contents)); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	sha := sha256.Sum256([]byte(contents))
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write([]byte(contents))
+	f.headers = map[string]http.Header{"data.txt": {
+		"X-Amz-Checksum-Sha256": {base64.StdEncoding.EncodeToString(sha[:])},
+		"X-Amz-Checksum-Crc32c": {base64.StdEncoding.EncodeToString(crc.Sum(nil))},
+	}}
+
+	for _, options := range []CatOptions{{Verify: true}, {Verify: true, HeadLines: 1}, {Verify: true, TailLines: 1, Pretty: true}} {
+		if err := CatWithOptions(&bytes.Buffer{}, "s3://bucket/data.txt", options); err != nil {
+			t.Errorf("tc: %+v unexpected error %v", options, err)
+		}
+	}
+
+	// Corrupt the object behind the checksums' backs
+	f.objects["data.txt"] = []byte("line 1\nline 3\n")
+	err := CatWithOptions(&bytes.Buffer{}, "s3://bucket/data.txt", CatOptions{Verify: true})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected %q, got %v", ErrChecksumMismatch, err)
+	}
+
+	local := filepath.Join(t.TempDir(), "local.txt")
+	os.WriteFile(local, []byte(contents), 0o644)
+	if err := CatWithOptions(&bytes.Buffer{}, local, CatOptions{Verify: true}); err == nil {
+		t.Errorf("expected an error for a local file without checksums")
+	}
+	if err := CatWithOptions(&bytes.Buffer{}, "s3://bucket/data.txt", CatOptions{Verify: true, Range: &ByteRange{Length: 4}}); err == nil {
+		t.Errorf("expected an error for verifying a byte range")
+	}
+}
@@ -0,0 +1,208 @@
+package koshka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-webdav"
+)
+
+//
+// WebDAV servers, e.g. Nextcloud and Fastmail files.  webdav:// URLs talk
+// HTTP, and davs:// URLs talk HTTPS.  The matching sections of kot.cfg
+// configure them the same way as for http_do, e.g.
+//
+//	[davs://myfiles.fastmail.com]
+//	username = me@fastmail.com
+//	password = app-password
+//
+type webdavBackend struct{}
+
+func init() {
+	Register("webdav", webdavBackend{})
+	Register("davs", webdavBackend{})
+}
+
+func (webdavBackend) Open(rawUrl string) (io.ReadCloser, error) {
+	client, path, err := webdav_client(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := client.Open(context.TODO(), path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read from url %q: %w", rawUrl, err)
+	}
+	return reader, nil
+}
+
+func (webdavBackend) Create(rawUrl string) (io.WriteCloser, error) {
+	return webdav_create(rawUrl, "")
+}
+
+func (webdavBackend) CreateIfMatch(rawUrl string, etag string) (io.WriteCloser, error) {
+	return webdav_create(rawUrl, etag)
+}
+
+//
+// Upload with a plain PUT, like the WebDAV client does, but in a way that we
+// can abandon, and make conditional on the ETag
+//
+func webdav_create(rawUrl string, etag string) (io.WriteCloser, error) {
+	parsedUrl, err := webdav_http_url(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if parsedUrl.Path == "" || strings.HasSuffix(parsedUrl.Path, "/") {
+		return nil, fmt.Errorf("unable to write to url %q: not a file", rawUrl)
+	}
+	return http_create(parsedUrl.String(), rawUrl, http_if_match(etag)), nil
+}
+
+func (webdavBackend) Remove(rawUrl string) error {
+	client, path, err := webdav_client(rawUrl)
+	if err != nil {
+		return err
+	}
+	// DELETE removes collections along with everything in them, unlike rm
+	info, err := client.Stat(context.TODO(), path)
+	if err != nil {
+		return fmt.Errorf("unable to stat url %q: %w", rawUrl, err)
+	}
+	if info.IsDir {
+		return fmt.Errorf("unable to remove url %q: is a directory", rawUrl)
+	}
+	if err := client.RemoveAll(context.TODO(), path); err != nil {
+		return fmt.Errorf("unable to remove url %q: %w", rawUrl, err)
+	}
+	return nil
+}
+
+func (webdavBackend) Stat(rawUrl string) (ObjectInfo, error) {
+	client, path, err := webdav_client(rawUrl)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := client.Stat(context.TODO(), path)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("unable to stat url %q: %w", rawUrl, err)
+	}
+	return ObjectInfo{
+		Url:         rawUrl,
+		Size:        info.Size,
+		ModTime:     info.ModTime,
+		IsDir:       info.IsDir,
+		ETag:        info.ETag,
+		ContentType: info.MIMEType,
+	}, nil
+}
+
+func (webdavBackend) List(prefix string) ([]string, error) {
+	return webdav_list(prefix)
+}
+
+//
+// Sends the requests of the WebDAV client the same way as http_do, so that
+// e.g. authentication works the same way
+//
+type webdavHttpClient struct {
+	rawUrl string
+}
+
+func (c webdavHttpClient) Do(request *http.Request) (*http.Response, error) {
+	client, err := http_configure(request, c.rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+//
+// The http:// or https:// URL that a webdav:// or davs:// URL stands for
+//
+func webdav_http_url(rawUrl string) (*url.URL, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if parsedUrl.Host == "" {
+		return nil, fmt.Errorf("malformed WebDAV url: %q", rawUrl)
+	}
+	if parsedUrl.Scheme == "davs" {
+		parsedUrl.Scheme = "https"
+	} else {
+		parsedUrl.Scheme = "http"
+	}
+	return parsedUrl, nil
+}
+
+//
+// Return a client for the server of rawUrl, and the path on that server
+//
+func webdav_client(rawUrl string) (*webdav.Client, string, error) {
+	parsedUrl, err := webdav_http_url(rawUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	endpoint := fmt.Sprintf("%s://%s/", parsedUrl.Scheme, parsedUrl.Host)
+	client, err := webdav.NewClient(webdavHttpClient{rawUrl}, endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	path := parsedUrl.Path
+	if path == "" {
+		path = "/"
+	}
+	return client, path, nil
+}
+
+//
+// List a collection with PROPFIND
+//
+func webdav_list(prefix string) (candidates []string, err error) {
+	parsedUrl, err := url.Parse(prefix)
+	if err != nil {
+		return candidates, err
+	}
+	if parsedUrl.Host == "" || !strings.HasPrefix(parsedUrl.Path, "/") {
+		return candidates, nil
+	}
+	client, path, err := webdav_client(prefix)
+	if err != nil {
+		return candidates, err
+	}
+
+	slash := strings.LastIndex(path, "/")
+	dir, base := path[:slash+1], path[slash+1:]
+	entries, err := client.ReadDir(context.TODO(), dir, false)
+	if err != nil {
+		return candidates, fmt.Errorf("unable to list url %q: %w", prefix, err)
+	}
+
+	root := parsedUrl.Scheme + "://" + parsedUrl.Host
+	for _, entry := range entries {
+		entryPath := strings.TrimSuffix(entry.Path, "/")
+		// The listing includes the collection itself
+		if entryPath == strings.TrimSuffix(dir, "/") || !strings.HasPrefix(entryPath, dir) {
+			continue
+		}
+		name := entryPath[len(dir):]
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if entry.IsDir {
+			entryPath += "/"
+		}
+		candidates = append(candidates, root+(&url.URL{Path: entryPath}).EscapedPath())
+	}
+	// The server returns the entries in no particular order
+	sort.Strings(candidates)
+	return candidates, nil
+}
@@ -0,0 +1,133 @@
+package koshka
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/emersion/go-webdav"
+)
+
+//
+// Serve dir over WebDAV, behind basic auth, and return the webdav:// URL of
+// the server
+//
+func setupFakeWebdav(t *testing.T, dir string) string {
+	handler := &webdav.Handler{FileSystem: webdav.LocalFileSystem(dir)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	rawUrl := "webdav://" + strings.TrimPrefix(server.URL, "http://")
+	cfg := "[" + rawUrl + "]\nusername = user\npassword = pass\n"
+	if err := os.WriteFile(filepath.Join(home, "kot.cfg"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return rawUrl
+}
+
+func Test_webdav_list(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"docs/report.txt", "docs/read me.md", "docs/.hidden", "docs/old/notes.txt", "top.txt"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := setupFakeWebdav(t, dir)
+
+	testCases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{root + "/", []string{root + "/docs/", root + "/top.txt"}},
+		{root + "/docs/", []string{root + "/docs/old/", root + "/docs/read%20me.md", root + "/docs/report.txt"}},
+		{root + "/docs/re", []string{root + "/docs/read%20me.md", root + "/docs/report.txt"}},
+		{root + "/docs/.h", []string{root + "/docs/.hidden"}},
+	}
+	for _, tc := range testCases {
+		actual, err := webdav_list(tc.prefix)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected error %v", tc.prefix, err)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc.prefix, tc.expected, actual)
+		}
+	}
+}
+
+func TestPut_webdav(t *testing.T) {
+	dir := t.TempDir()
+	root := setupFakeWebdav(t, dir)
+
+	rawUrl := root + "/read%20me.md"
+	if err := Put(rawUrl, strings.NewReader("# hello")); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if actual, _ := os.ReadFile(filepath.Join(dir, "read me.md")); string(actual) != "# hello" {
+		t.Errorf("expected %q, got %q", "# hello", actual)
+	}
+
+	var buffer bytes.Buffer
+	if err := CatWithOptions(&buffer, rawUrl, CatOptions{}); err != nil {
+		t.Fatalf("unexpected err: %q", err)
+	}
+	if actual := buffer.String(); actual != "# hello" {
+		t.Errorf("expected %q, got %q", "# hello", actual)
+	}
+
+	info, err := Stat(rawUrl)
+	if err != nil || info.Size != 7 || info.ETag == "" {
+		t.Errorf("expected a 7 byte object with an ETag, got %v (%v)", info, err)
+	}
+
+	if err := Remove(root + "/"); err == nil {
+		t.Errorf("expected removing a directory to fail")
+	}
+	if err := Remove(rawUrl); err != nil {
+		t.Errorf("unexpected err: %q", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "read me.md")); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed", rawUrl)
+	}
+	if _, err := Open(root + "/missing.txt"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestPut_webdavAbort(t *testing.T) {
+	bodyErrs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		bodyErrs <- err
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("HOME", t.TempDir())
+
+	rawUrl := "webdav://" + strings.TrimPrefix(server.URL, "http://") + "/notes.txt"
+	failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := Put(rawUrl, failing); err == nil {
+		t.Errorf("expected a failing reader to fail the upload")
+	}
+	// The server must be able to tell the upload apart from a short file
+	if err := <-bodyErrs; err == nil {
+		t.Errorf("expected the request body to be cut short")
+	}
+}
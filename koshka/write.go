@@ -0,0 +1,299 @@
+package koshka
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//
+// A WriteBackend can create objects, or overwrite existing ones
+//
+type WriteBackend interface {
+	Create(rawUrl string) (io.WriteCloser, error)
+}
+
+//
+// Create returns a writer for the object at rawUrl.  The object is complete
+// once the writer is closed successfully, so always check the result of
+// Close.  The special URL "-" writes to standard output.
+//
+func Create(rawUrl string) (io.WriteCloser, error) {
+	if rawUrl == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	writeBackend, ok := backend.(WriteBackend)
+	if !ok {
+		return nil, fmt.Errorf("writing is not supported for url %q", rawUrl)
+	}
+	writer, err := writeBackend.Create(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{writer, rawUrl}, nil
+}
+
+//
+// A ConditionalWriteBackend can have the server refuse to overwrite an
+// object that no longer has a particular ETag, so that nobody can sneak in
+// between checking the object and writing it
+//
+type ConditionalWriteBackend interface {
+	CreateIfMatch(rawUrl string, etag string) (io.WriteCloser, error)
+}
+
+//
+// Put writes everything from reader to the object at rawUrl
+//
+func Put(rawUrl string, reader io.Reader) error {
+	writer, err := Create(rawUrl)
+	if err != nil {
+		return err
+	}
+	return copyAndClose(writer, rawUrl, reader)
+}
+
+//
+// Copy everything from reader to writer, and complete the object at rawUrl,
+// or abandon it if reading fails
+//
+func copyAndClose(writer io.WriteCloser, rawUrl string, reader io.Reader) error {
+	if _, err := io.Copy(writer, reader); err != nil {
+		if aborter, ok := writer.(interface{ CloseWithError(error) error }); ok {
+			aborter.CloseWithError(err)
+		} else {
+			writer.Close()
+		}
+		return fmt.Errorf("unable to write to url %q: %w", rawUrl, err)
+	}
+	return writer.Close()
+}
+
+//
+// Drop the cached listings that the new object would appear in, once it
+// exists
+//
+type invalidatingWriter struct {
+	io.WriteCloser
+	rawUrl string
+}
+
+func (w *invalidatingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	InvalidateCache(w.rawUrl)
+	return err
+}
+
+func (w *invalidatingWriter) CloseWithError(err error) error {
+	if aborter, ok := w.WriteCloser.(interface{ CloseWithError(error) error }); ok {
+		return aborter.CloseWithError(err)
+	}
+	return w.WriteCloser.Close()
+}
+
+var ErrObjectChanged = errors.New("object changed since it was read")
+
+//
+// PutIfUnchanged is like Put, but refuses to overwrite the object at rawUrl
+// if it is no longer the same as when Stat returned previous, e.g. because
+// somebody else modified it while we were editing it.  Backends that can
+// make the write itself conditional do so, otherwise there is a small window
+// between checking and writing.
+//
+func PutIfUnchanged(rawUrl string, reader io.Reader, previous ObjectInfo) error {
+	current, err := Stat(rawUrl)
+	if err != nil {
+		return err
+	}
+	if !sameVersion(previous, current) {
+		return fmt.Errorf("unable to write to url %q: %w", rawUrl, ErrObjectChanged)
+	}
+
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return err
+	}
+	conditional, ok := backend.(ConditionalWriteBackend)
+	if !ok || previous.ETag == "" {
+		return Put(rawUrl, reader)
+	}
+	writer, err := conditional.CreateIfMatch(rawUrl, previous.ETag)
+	if err != nil {
+		return err
+	}
+	return copyAndClose(&invalidatingWriter{writer, rawUrl}, rawUrl, reader)
+}
+
+//
+// Compare the ETags if we have them, otherwise the best we can do is the
+// size and modification time
+//
+func sameVersion(a, b ObjectInfo) bool {
+	if a.ETag != "" || b.ETag != "" {
+		return a.ETag == b.ETag
+	}
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+//
+// A RemoveBackend can delete objects
+//
+type RemoveBackend interface {
+	Remove(rawUrl string) error
+}
+
+//
+// Remove deletes the object at rawUrl
+//
+func Remove(rawUrl string) error {
+	backend, err := backendFor(rawUrl)
+	if err != nil {
+		return err
+	}
+	removeBackend, ok := backend.(RemoveBackend)
+	if !ok {
+		return fmt.Errorf("removing is not supported for url %q", rawUrl)
+	}
+	err = removeBackend.Remove(rawUrl)
+	InvalidateCache(rawUrl)
+	return err
+}
+
+//
+// Copy the object at src to dst as is, without decompressing it.  Like cp,
+// copying to a directory keeps the original name, so dst may be e.g.
+// s3://bucket/dir/.  The special URL "-" copies from standard input.
+//
+func Copy(src, dst string) error {
+	if strings.HasSuffix(dst, "/") {
+		dst += baseName(src)
+	} else if info, err := Stat(dst); err == nil && info.IsDir {
+		dst += "/" + baseName(src)
+	}
+
+	if SameObject(src, dst) {
+		return fmt.Errorf("unable to copy url %q: it is the same object as %q", src, dst)
+	}
+
+	var reader io.ReadCloser = io.NopCloser(os.Stdin)
+	if src != "-" {
+		var err error
+		if reader, err = Open(src); err != nil {
+			return err
+		}
+	}
+	defer reader.Close()
+	return Put(dst, reader)
+}
+
+//
+// SameObject tells whether a and b refer to the same object, e.g. so that we
+// don't overwrite an object that we're reading from.  Local paths are the
+// same if they lead to the same file, other URLs only if they are equal.
+//
+func SameObject(a, b string) bool {
+	if a == "-" || b == "-" {
+		return false
+	}
+	if isLocal(a) && isLocal(b) {
+		aPath, aErr := local_path(a)
+		bPath, bErr := local_path(b)
+		if aErr != nil || bErr != nil {
+			return false
+		}
+		aInfo, aErr := os.Stat(aPath)
+		bInfo, bErr := os.Stat(bPath)
+		if aErr == nil && bErr == nil {
+			return os.SameFile(aInfo, bInfo)
+		}
+		aPath, _ = filepath.Abs(aPath)
+		bPath, _ = filepath.Abs(bPath)
+		return aPath == bPath
+	}
+	return a == b
+}
+
+func isLocal(rawUrl string) bool {
+	scheme := schemeOf(rawUrl)
+	return scheme == "" || scheme == "file"
+}
+
+//
+// The last component of the path in rawUrl, without any query
+//
+func baseName(rawUrl string) string {
+	switch schemeOf(rawUrl) {
+	case "", "file":
+		if path, err := local_path(rawUrl); err == nil {
+			return filepath.Base(path)
+		}
+	case "s3":
+		if parsedUrl, err := parseS3Url(rawUrl); err == nil {
+			return path.Base(parsedUrl.Key)
+		}
+	default:
+		if parsedUrl, err := url.Parse(rawUrl); err == nil {
+			return path.Base(parsedUrl.Path)
+		}
+	}
+	return path.Base(rawUrl)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+//
+// Adapt APIs that upload from a reader, e.g. an HTTP PUT, to a writer.  The
+// upload runs in the background while the caller writes, and Close waits for
+// the upload to finish.
+//
+type uploadWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func newUploadWriter(upload func(reader io.Reader) error) *uploadWriter {
+	pipeReader, pipeWriter := io.Pipe()
+	w := &uploadWriter{pipe: pipeWriter, done: make(chan error, 1)}
+	go func() {
+		err := upload(pipeReader)
+		if err != nil {
+			// Unblock the writer if the upload gave up before reading everything
+			pipeReader.CloseWithError(err)
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *uploadWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+//
+// Abandon the upload, so that a partial object doesn't get written
+//
+func (w *uploadWriter) CloseWithError(err error) error {
+	w.pipe.CloseWithError(err)
+	<-w.done
+	return nil
+}
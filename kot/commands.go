@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mpenkov/tools/koshka"
+)
+
+//
+// The subcommands of kot, so that we don't need separate binaries for
+// listing, copying, editing, etc.  All of them take URLs of any scheme that
+// koshka supports.
+//
+var subcommands = map[string]func(args []string){
+	"cache":   cacheCommand,
+	"config":  configCommand,
+	"cp":      cpCommand,
+	"edit":    editCommand,
+	"ls":      lsCommand,
+	"presign": presignCommand,
+	"query":   queryCommand,
+	"rm":      rmCommand,
+	"stat":    statCommand,
+}
+
+//
+// Show where each configuration value for a URL comes from
+//
+func configCommand(args []string) {
+	if len(args) != 2 || args[0] != "explain" {
+		fmt.Fprintln(os.Stderr, "usage: kot config explain <url>")
+		os.Exit(1)
+	}
+
+	values, err := koshka.ExplainConfig(args[1], "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, value := range values {
+		fmt.Fprintf(writer, "%s = %s\t[%s]", value.Key, value.MaskedValue(), value.Section)
+		for _, overridden := range value.Overridden {
+			fmt.Fprintf(writer, " overrides [%s]", overridden)
+		}
+		fmt.Fprintln(writer)
+	}
+	writer.Flush()
+}
+
+//
+// Manage the on-disk cache of completion listings
+//
+func cacheCommand(args []string) {
+	if len(args) != 1 || args[0] != "clear" {
+		fmt.Fprintln(os.Stderr, "usage: kot cache clear")
+		os.Exit(1)
+	}
+	if err := koshka.ClearCache(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//
+// List the entries under each URL, like ls
+//
+func lsCommand(args []string) {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	long := flags.Bool("l", false, "show the size and modification time of each entry")
+	flags.Parse(args)
+
+	prefixes := flags.Args()
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	for _, prefix := range prefixes {
+		entries, err := lsEntries(prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		for _, entry := range entries {
+			if !*long {
+				fmt.Println(entry)
+				continue
+			}
+			info, err := koshka.Stat(entry)
+			if err != nil {
+				log.Fatal(err)
+			}
+			modTime := ""
+			if !info.ModTime.IsZero() {
+				modTime = info.ModTime.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%d\t%s\t %s\n", info.Size, modTime, entry)
+		}
+		writer.Flush()
+	}
+}
+
+//
+// What ls shows for rawUrl: the object itself, or the contents of the
+// directory.  Listing alone would match by prefix, e.g. dir would also
+// show dir-old/, and notes.txt would also show notes.txt.bak.
+//
+func lsEntries(rawUrl string) ([]string, error) {
+	dir := rawUrl
+	var statErr error
+	if rawUrl != "" && !strings.HasSuffix(rawUrl, "/") {
+		info, err := koshka.Stat(rawUrl)
+		if err == nil && !info.IsDir {
+			return []string{rawUrl}, nil
+		}
+		// S3 and friends have no directory objects, so try the listing
+		// before giving up
+		dir, statErr = rawUrl+"/", err
+	}
+
+	entries, err := koshka.ListAll(dir)
+	if statErr != nil && (err != nil || len(entries) == 0) {
+		return nil, statErr
+	}
+	return entries, err
+}
+
+//
+// Show what we know about each object
+//
+func statCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: kot stat <url>...")
+		os.Exit(1)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for i, rawUrl := range args {
+		info, err := koshka.Stat(rawUrl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+		fmt.Fprintf(writer, "Url:\t%s\n", info.Url)
+		fmt.Fprintf(writer, "Size:\t%d\n", info.Size)
+		if !info.ModTime.IsZero() {
+			fmt.Fprintf(writer, "Modified:\t%s\n", info.ModTime.Format(time.RFC3339))
+		}
+		fmt.Fprintf(writer, "Directory:\t%t\n", info.IsDir)
+		for _, field := range [][2]string{
+			{"ETag", info.ETag},
+			{"Storage class", info.StorageClass},
+			{"Content type", info.ContentType},
+			{"MD5", info.Checksums["md5"]},
+			{"CRC32C", info.Checksums["crc32c"]},
+			{"SHA256", info.Checksums["sha256"]},
+		} {
+			if field[1] != "" {
+				fmt.Fprintf(writer, "%s:\t%s\n", field[0], field[1])
+			}
+		}
+
+		keys := make([]string, 0, len(info.Metadata))
+		for key := range info.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for j, key := range keys {
+			label := ""
+			if j == 0 {
+				label = "Metadata:"
+			}
+			fmt.Fprintf(writer, "%s\t%s: %s\n", label, key, info.Metadata[key])
+		}
+	}
+	writer.Flush()
+}
+
+//
+// Copy objects, like cp.  With several sources, the destination is a
+// directory, e.g. s3://bucket/dir/
+//
+func cpCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: kot cp <src>... <dst>")
+		os.Exit(1)
+	}
+
+	sources, dst := args[:len(args)-1], args[len(args)-1]
+	if len(sources) > 1 && !strings.HasSuffix(dst, "/") {
+		dst += "/"
+	}
+	for _, src := range sources {
+		if err := koshka.Copy(src, dst); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+//
+// Remove objects, like rm
+//
+func rmCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: kot rm <url>...")
+		os.Exit(1)
+	}
+	for _, rawUrl := range args {
+		if err := koshka.Remove(rawUrl); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+//
+// Edit an object using $EDITOR, and write it back if anything changed.  If
+// somebody else changed the object in the meantime, keep our version in a
+// temporary file instead of clobbering theirs, unless forced.
+//
+func editCommand(args []string) {
+	flags := flag.NewFlagSet("edit", flag.ExitOnError)
+	force := flags.Bool("f", false, "overwrite the object even if it changed while editing")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: kot edit [-f] <url>")
+		os.Exit(1)
+	}
+	rawUrl := flags.Arg(0)
+
+	// Stat before reading, so that we notice any change made after this point
+	info, err := koshka.Stat(rawUrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader, err := koshka.Open(rawUrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	original, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Keep the extension, so that the editor can highlight the syntax
+	tempFile, err := os.CreateTemp("", "kot-*"+path.Ext(rawUrl))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = tempFile.Write(original)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		log.Fatal(err)
+	}
+
+	editor := os.ExpandEnv("$EDITOR")
+	if editor == "$EDITOR" || editor == "" {
+		editor = "vim"
+	}
+	command := exec.Command(editor, tempFile.Name())
+	command.Stdin = os.Stdin
+	command.Stderr = os.Stderr
+	command.Stdout = os.Stdout
+	if err := command.Run(); err != nil {
+		log.Fatalf("%s failed, your changes are in %s: %s", editor, tempFile.Name(), err)
+	}
+
+	edited, err := os.ReadFile(tempFile.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bytes.Equal(original, edited) {
+		os.Remove(tempFile.Name())
+		fmt.Fprintln(os.Stderr, "no changes, not writing", rawUrl)
+		return
+	}
+
+	if *force {
+		err = koshka.Put(rawUrl, bytes.NewReader(edited))
+	} else {
+		err = koshka.PutIfUnchanged(rawUrl, bytes.NewReader(edited), info)
+	}
+	if errors.Is(err, koshka.ErrObjectChanged) {
+		log.Fatalf("%s, your changes are in %s, use -f to overwrite", err, tempFile.Name())
+	} else if err != nil {
+		log.Fatalf("%s, your changes are in %s", err, tempFile.Name())
+	}
+	os.Remove(tempFile.Name())
+}
+
+//
+// Run an S3 Select query against a CSV or JSON lines object, e.g.
+//
+//	kot query s3://bucket/people.csv "SELECT s.name FROM S3Object s WHERE s.age > 30"
+//
+// The same query works for other schemes, by evaluating it locally.
+//
+func queryCommand(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	var options koshka.QueryOptions
+	flags.StringVar(&options.InputFormat, "input", "", "the format of the object: csv or json (lines), by default guessed from the extension")
+	flags.StringVar(&options.OutputFormat, "output", "", "the format of the output: csv or json (lines), by default the same as the input")
+	flags.BoolVar(&options.NoHeader, "no-header", false, "the first line of CSV input is data, not column names")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: kot query [-input csv|json] [-output csv|json] [-no-header] <url> <sql>")
+		os.Exit(1)
+	}
+
+	if err := koshka.Query(os.Stdout, flags.Arg(0), flags.Arg(1), options); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//
+// Print presigned URLs for sharing objects with people who don't have
+// credentials of their own, e.g.
+//
+//	kot presign s3://bucket/report.csv --expires 24h
+//
+func presignCommand(args []string) {
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	expires := flags.Duration("expires", time.Hour, "how long the URL stays valid, at most 168h")
+	put := flags.Bool("put", false, "allow uploading to the URL instead of downloading from it")
+	rawUrls := parseInterspersed(flags, args)
+	if len(rawUrls) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: kot presign [-expires 1h] [-put] <url>...")
+		os.Exit(1)
+	}
+
+	method := http.MethodGet
+	if *put {
+		method = http.MethodPut
+	}
+	for _, rawUrl := range rawUrls {
+		presigned, err := koshka.Presign(rawUrl, method, *expires)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(presigned)
+	}
+}
+
+//
+// Parse the flags wherever they are among the arguments, unlike
+// FlagSet.Parse, which stops at the first argument that isn't a flag.
+// Everything after -- is positional, even if it looks like a flag.
+//
+func parseInterspersed(flags *flag.FlagSet, args []string) (positional []string) {
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		if consumed := len(args) - flags.NArg(); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, flags.Args()...)
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
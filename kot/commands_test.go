@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//
+// Run the test binary as kot itself when KOT_TEST_MAIN is set, so that the
+// tests can check the exit status and output of whole commands
+//
+func TestMain(m *testing.M) {
+	if os.Getenv("KOT_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runKot(args ...string) (lines []string, err error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "KOT_TEST_MAIN=1")
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	if text := strings.TrimSuffix(stdout.String(), "\n"); text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	return lines, err
+}
+
+func Test_lsCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	for _, name := range []string{"dir/a.txt", "dir/b.txt", "dir-old/c.txt", "notes.txt", "notes.txt.bak"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := map[string][]string{
+		dir + "/dir":       {dir + "/dir/a.txt", dir + "/dir/b.txt"},
+		dir + "/dir/":      {dir + "/dir/a.txt", dir + "/dir/b.txt"},
+		dir + "/notes.txt": {dir + "/notes.txt"},
+	}
+	for tc, expected := range testCases {
+		actual, err := runKot("ls", tc)
+		if err != nil {
+			t.Fatalf("tc: %q unexpected err: %q", tc, err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("tc: %q expected %q, got %q", tc, expected, actual)
+		}
+	}
+
+	if actual, err := runKot("ls", dir+"/missing"); err == nil {
+		t.Errorf("expected an error for a missing url, got %q", actual)
+	}
+}
@@ -4,26 +4,33 @@ package main
 // [x] List S3 objects matching a given prefix
 // [x] Stream a specific S3 object
 // [x] Integrate with autocompletion
-// [ ] Support for S3 versions
-// [ ] Support for aliases
-// [ ] Handle HTTP/S
-// [ ] Handle local files
-// [ ] Any other backends?
+// [x] Support for S3 versions
+// [x] Support for aliases
+// [.] Handle HTTP/S
+// [x] Handle local files
+// [x] Any other backends?  SFTP, WebDAV
 // [.] Tests!!
-// [ ] GNU cat-compatible command-line flags
+// [x] GNU cat-compatible command-line flags
 // [ ] Proper packaging
 // [ ] CI to build binaries for MacOS, Windows and Linux
 
 // [x] Where's the AWS SDK golang reference?  https://pkg.go.dev/github.com/aws/aws-sdk-go-v2
-// [ ] How to package this thing without having to build separate binaries for kot, kedit, etc?
+// [x] How to package this thing without having to build separate binaries for kot, kedit, etc?
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/mpenkov/tools/koshka"
 	"github.com/posener/complete/v2"
+	"github.com/posener/complete/v2/predict"
 )
 
 type PredictorType int
@@ -36,26 +43,200 @@ func (mpt PredictorType) Predict(prefix string) (candidates []string) {
 	return []string{}
 }
 
+//
+// GNU cat lets you combine single-letter flags, e.g. -ns, but the flag
+// package doesn't, so split them up ourselves
+//
+func splitShortFlags(args []string) (result []string) {
+	const shortFlags = "AbeEnstTuv"
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i:]...)
+		}
+		name := strings.TrimPrefix(arg, "-")
+		isCombined := len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && flag.Lookup(name) == nil
+		for _, c := range name {
+			isCombined = isCombined && strings.ContainsRune(shortFlags, c)
+		}
+		if !isCombined {
+			result = append(result, arg)
+			continue
+		}
+		for _, c := range name {
+			result = append(result, "-"+string(c))
+		}
+	}
+	return result
+}
+
 func main() {
 	var testFlag = flag.Bool("test", false, "test the predictor")
+	var versionsFlag = flag.Bool("versions", false, "list the versions of an S3 object")
+	var rawFlag = flag.Bool("raw", false, "do not decompress compressed input")
+	var verifyFlag = flag.Bool("verify", false, "check the object against its stored MD5, CRC32C or SHA256 checksum")
+	var prettyFlag = flag.Bool("pretty", false, "format JSON and CSV for reading, and summarize Parquet and Avro schemas")
+	var rangeFlag = flag.String("range", "", "read only the specified byte range, e.g. 0-99, 100- or -100")
+	var headFlag = flag.Int("head", 0, "output only the first N lines")
+	var headBytesFlag = flag.Int64("head-bytes", 0, "output only the first N bytes")
+	var tailFlag = flag.Int("tail", 0, "output only the last N lines")
+	var tailBytesFlag = flag.Int64("tail-bytes", 0, "output only the last N bytes")
+	var outputFlag = flag.String("o", "-", "write the output to this URL instead of standard output")
+	var jobsFlag = flag.Int("j", 8, "fetch up to N objects at the same time")
+
+	var format koshka.FormatOptions
+	var showAll, showEndsNonPrinting, showTabsNonPrinting bool
+	flag.BoolVar(&showAll, "A", false, "equivalent to -vET")
+	flag.BoolVar(&format.NumberNonBlank, "b", false, "number nonempty output lines, overrides -n")
+	flag.BoolVar(&showEndsNonPrinting, "e", false, "equivalent to -vE")
+	flag.BoolVar(&format.ShowEnds, "E", false, "display $ at end of each line")
+	flag.BoolVar(&format.NumberLines, "n", false, "number all output lines")
+	flag.BoolVar(&format.SqueezeBlank, "s", false, "suppress repeated empty output lines")
+	flag.BoolVar(&showTabsNonPrinting, "t", false, "equivalent to -vT")
+	flag.BoolVar(&format.ShowTabs, "T", false, "display TAB characters as ^I")
+	flag.Bool("u", false, "(ignored)")
+	flag.BoolVar(&format.ShowNonPrinting, "v", false, "use ^ and M- notation, except for LFD and TAB")
 
 	var predictor PredictorType
-	cmd := &complete.Command{Args: predictor}
+	cmd := &complete.Command{
+		Sub: map[string]*complete.Command{
+			"cache":  {Sub: map[string]*complete.Command{"clear": {}}},
+			"config": {Sub: map[string]*complete.Command{"explain": {Args: predictor}}},
+			"cp":     {Args: predictor},
+			"edit":   {Args: predictor, Flags: map[string]complete.Predictor{"f": nil}},
+			"ls":     {Args: predictor, Flags: map[string]complete.Predictor{"l": nil}},
+			"presign": {
+				Args:  predictor,
+				Flags: map[string]complete.Predictor{"expires": predict.Nothing, "put": nil},
+			},
+			"query": {
+				Args: predictor,
+				Flags: map[string]complete.Predictor{
+					"input":     predict.Set{"csv", "json"},
+					"output":    predict.Set{"csv", "json"},
+					"no-header": nil,
+				},
+			},
+			"rm":   {Args: predictor},
+			"stat": {Args: predictor},
+		},
+		Args: predictor,
+	}
 	cmd.Complete("kot")
 
-	flag.Parse()
+	//
+	// Without a subcommand, kot behaves like cat.  To cat a local file that
+	// has the same name as a subcommand, use e.g. ./ls
+	//
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	//
+	// Like GNU cat, accept flags after the operands too, e.g. kot notes.txt -n
+	//
+	operands := parseInterspersed(flag.CommandLine, splitShortFlags(os.Args[1:]))
+	if showAll {
+		format.ShowNonPrinting, format.ShowEnds, format.ShowTabs = true, true, true
+	}
+	if showEndsNonPrinting {
+		format.ShowNonPrinting, format.ShowEnds = true, true
+	}
+	if showTabsNonPrinting {
+		format.ShowNonPrinting, format.ShowTabs = true, true
+	}
 
 	if *testFlag {
-		for _, thing := range predictor.Predict(flag.Args()[0]) {
+		for _, thing := range predictor.Predict(operands[0]) {
 			fmt.Println(thing)
 		}
 		return
 	}
 
-	for _, thing := range flag.Args() {
-		err := koshka.Cat(thing)
+	if *versionsFlag {
+		for _, thing := range operands {
+			versions, err := koshka.ListVersions(thing)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, v := range versions {
+				latest := ""
+				if v.IsLatest {
+					latest = "latest"
+				}
+				fmt.Printf("%s\t%s\t%d\t%s\n", v.Url, v.ModTime.Format(time.RFC3339), v.Size, latest)
+			}
+		}
+		return
+	}
+
+	options := koshka.CatOptions{
+		Raw:       *rawFlag,
+		HeadLines: *headFlag,
+		TailLines: *tailFlag,
+		Pretty:    *prettyFlag,
+		Verify:    *verifyFlag,
+	}
+	switch {
+	case *rangeFlag != "":
+		byteRange, err := koshka.ParseByteRange(*rangeFlag)
 		if err != nil {
 			log.Fatal(err)
 		}
+		options.Range = &byteRange
+	case *headBytesFlag > 0:
+		options.Range = &koshka.ByteRange{Offset: 0, Length: *headBytesFlag}
+	case *tailBytesFlag > 0:
+		options.Range = &koshka.ByteRange{Offset: -*tailBytesFlag}
+	}
+
+	var rawUrls []string
+	for _, thing := range operands {
+		if thing == "-" {
+			rawUrls = append(rawUrls, thing)
+			continue
+		}
+		matches, err := koshka.Glob(thing)
+		if err != nil && !errors.Is(err, path.ErrBadPattern) {
+			log.Fatal(err)
+		}
+		// Like the shell, take the argument as is if it matches nothing, e.g.
+		// for keys that really contain * or [
+		if len(matches) == 0 {
+			matches = []string{thing}
+		}
+		rawUrls = append(rawUrls, matches...)
+	}
+	if len(rawUrls) == 0 {
+		rawUrls = []string{"-"}
+	}
+	for _, rawUrl := range rawUrls {
+		// Writing the output would clobber the input before we've read it
+		if koshka.SameObject(rawUrl, *outputFlag) {
+			log.Fatalf("input %q is the same as the output", rawUrl)
+		}
+	}
+
+	output, err := koshka.Create(*outputFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var writer io.Writer = output
+	if format != (koshka.FormatOptions{}) {
+		writer = koshka.NewFormatter(output, format)
+	}
+
+	if err := koshka.CatAll(writer, rawUrls, options, *jobsFlag); err != nil {
+		// Abandon the output, so that a partial upload never completes
+		if aborter, ok := output.(interface{ CloseWithError(error) error }); ok {
+			aborter.CloseWithError(err)
+		}
+		log.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
 	}
 }